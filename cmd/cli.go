@@ -1,17 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/goodbins/go-jenkins-exporter/config"
 	"github.com/goodbins/go-jenkins-exporter/exporter"
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -30,53 +34,113 @@ func RootCommand() *cobra.Command {
 
 Note: To setup jenkins credentials, use these environment variables:
 JENKINS_USERNAME, JENKINS_PASSWORD and/or JENKINS_TOKEN
-If they are not set, we assume no credentials.`,
+or the jenkins section of the configuration file.
+If they are not set, we assume no credentials.
+
+Flags take precedence over the environment variables, which take
+precedence over the configuration file.`,
 		Run:     run,
 		Version: config.CurrentVersion,
 	}
 
 	// Define and init flags
-	cobraCmd.Flags().BoolVarP(&config.Global.SSLOn, "ssl", "s", false, "Enable TLS (default false)")                                  // Optional
-	cobraCmd.Flags().StringVarP(&config.Global.JenkinsAPIHostPort, "jenkins", "j", "", "Jenkins API host:port pair")                  // Mendatory
-	cobraCmd.Flags().StringVarP(&config.Global.JenkinsAPIPath, "path", "a", "/api/json", "Jenkins API path")                          // Optional
-	cobraCmd.Flags().DurationVarP(&config.Global.JenkinsAPITimeout, "timeout", "t", 10*time.Second, "Jenkins API timeout in seconds") // Optional
-	cobraCmd.Flags().StringVarP(&config.Global.ExporterHostPort, "listen", "l", "localhost:5000", "Exporter host:port pair")          // Optional
-	cobraCmd.Flags().StringVarP(&config.Global.MetricsPath, "metrics", "m", "/metrics", "Path under which to expose metrics")         // Optional
-	cobraCmd.Flags().DurationVarP(&config.Global.MetricsUpdateRate, "rate", "r", 1*time.Second, "Set metrics update rate in seconds") // Optional
-	cobraCmd.Flags().BoolVarP(&config.Global.Verbose, "verbose", "v", false, "Enable verbosity. Overrides log flag")                                      // Optional
-	cobraCmd.Flags().StringVar(&config.Global.LogLevel, "log", "info", "Log level, one of: info, debug, warn, error, fatal")          // Optional
-	viper.BindEnv("username", "JENKINS_USERNAME")                                                                                     // Optional/Mendatory
-	viper.BindEnv("password", "JENKINS_PASSWORD")                                                                                     // Optional/Mendatory
-	viper.BindEnv("token", "JENKINS_TOKEN")                                                                                           // Optional/Mendatory
-	config.Global.JenkinsUsername = viper.GetString("username")
-	config.Global.JenkinsPassword = viper.GetString("password")
-	config.Global.JenkinsToken = viper.GetString("token")
-	config.Global.JenkinsWithCreds = true
+	cobraCmd.PersistentFlags().BoolVarP(&config.Global.SSLOn, "ssl", "s", false, "Enable TLS (default false)")                                  // Optional
+	cobraCmd.PersistentFlags().StringVarP(&config.Global.JenkinsAPIHostPort, "jenkins", "j", "", "Jenkins API host:port pair")                  // Mendatory
+	cobraCmd.PersistentFlags().StringVarP(&config.Global.JenkinsAPIPath, "path", "a", "/api/json", "Jenkins API path")                          // Optional
+	cobraCmd.PersistentFlags().DurationVarP(&config.Global.JenkinsAPITimeout, "timeout", "t", 10*time.Second, "Jenkins API timeout in seconds") // Optional
+	cobraCmd.PersistentFlags().Int64Var(&config.Global.MaxReplyBytes, "max-reply-bytes", 0, "Size of the Jenkins replies above which the crawl fails, 0 for unlimited") // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.TLSCAFile, "tls.ca-file", "", "CA certificates file to verify Jenkins, added to the system ones") // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.TLSCertFile, "tls.cert-file", "", "Client certificate file presented to Jenkins") // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.TLSKeyFile, "tls.key-file", "", "Client certificate key file") // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.TLSMinVersion, "tls.min-version", "1.2", "Minimum TLS version, one of: 1.0, 1.1, 1.2, 1.3") // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.TLSServerName, "tls.server-name", "", "Server name used to verify the Jenkins certificate, the host by default") // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.TLSInsecureSkipVerify, "tls.insecure-skip-verify", false, "Don't verify the Jenkins certificate, insecure") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.RetryMax, "retry.max", 3, "Number of retries of the reads failing on a connection error, a 429 or a 5xx, 0 to disable") // Optional
+	cobraCmd.PersistentFlags().DurationVar(&config.Global.RetryBackoff, "retry.backoff", 500*time.Millisecond, "Delay before the first retry, doubled on each retry") // Optional
+	cobraCmd.PersistentFlags().DurationVar(&config.Global.RetryMaxBackoff, "retry.max-backoff", 10*time.Second, "Maximum delay between two retries, longer Retry-After aren't waited") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.BreakerFailures, "breaker.failures", 5, "Number of failed requests in a row opening the circuit breaker of a Jenkins instance, 0 to disable") // Optional
+	cobraCmd.PersistentFlags().DurationVar(&config.Global.BreakerCooldown, "breaker.cooldown", 30*time.Second, "Time the circuit breaker stays open before letting a trial request through") // Optional
+	cobraCmd.PersistentFlags().Float64Var(&config.Global.LimitRate, "limit.rate", 0, "Maximum number of requests per second to a Jenkins instance, 0 for unlimited") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.LimitBurst, "limit.burst", 10, "Number of requests sent at once above --limit.rate after a pause") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.LimitInFlight, "limit.in-flight", 0, "Maximum number of requests in flight to a Jenkins instance, 0 for unlimited") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.CrawlWorkers, "workers", 4, "Number of Jenkins folders crawled concurrently")              // Optional
+	cobraCmd.PersistentFlags().DurationVar(&config.Global.CrawlTimeout, "crawl-timeout", 5*time.Minute, "Deadline of a whole crawl of Jenkins, 0 to disable") // Optional
+	cobraCmd.PersistentFlags().DurationVar(&config.Global.CrawlIdleInterval, "crawl-idle-interval", 0, "Interval between two fetches of the folders without building job, 0 to fetch them on every crawl") // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.CrawlStrategy, "crawl-strategy", exporter.StrategyFolder, "Crawl strategy, one of: folder, tree") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.CrawlTreeDepth, "crawl-tree-depth", 3, "Levels of folders fetched by each request of the tree strategy, up to 5") // Optional
+	cobraCmd.PersistentFlags().Int64Var(&config.Global.CrawlTreeMaxBytes, "crawl-tree-max-bytes", 32<<20, "Size of the tree replies above which the folders are fetched one by one") // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.PipelineStages, "stages", false, "Enable the pipeline stage metrics from the workflow API")  // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.MaxStages, "max-stages", 20, "Maximum number of stages exported per pipeline build")       // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.TestReport, "test-report", false, "Enable the per suite and per case metrics from the test reports") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.TestReportTop, "test-report-top", 10, "Number of slowest and failing test cases exported per job, 0 for none")   // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.MetricStyle, "metric-style", exporter.MetricStyleLegacy, "Style of the result, color and cause metrics, one of: legacy, stateset") // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.BuildHistory, "history", false, "Enable the build counters and duration histograms from the build history") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.HistoryBuilds, "history-builds", 20, "Number of builds fetched per job for the build counters and histograms") // Optional
+	cobraCmd.PersistentFlags().Float64SliceVar(&config.Global.HistoryDurationBuckets, "history-duration-buckets", []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}, "Buckets in seconds of the build duration histograms") // Optional
+	cobraCmd.PersistentFlags().Float64SliceVar(&config.Global.HistoryQueueBuckets, "history-queue-buckets", []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}, "Buckets in seconds of the build queuing duration histogram") // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.HistoryStateFile, "history-state-file", "", "File keeping the last observed builds across restarts") // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.HistoryCountFirst, "history-count-first", false, "Count the fetched builds of the jobs seen for the first time, counted again on each restart without --history-state-file") // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.JobLabels, "job-labels", false, "Add the folder, project, branch and job_class labels to the job metrics") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.JobsMaxDepth, "max-depth", 0, "Maximum folder depth of the exported jobs, 0 for unlimited") // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.JobsSkipDisabled, "skip-disabled", false, "Leave the disabled jobs out of the metrics") // Optional
+	cobraCmd.PersistentFlags().StringVarP(&config.Global.ExporterHostPort, "listen", "l", "localhost:5000", "Exporter host:port pair")          // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.WebConfigFile, "web.config.file", "", "Path to a web configuration file enabling TLS or basic authentication") // Optional
+	cobraCmd.PersistentFlags().DurationVar(&config.Global.ShutdownTimeout, "web.shutdown-timeout", 15*time.Second, "Grace period given to the scrapes and crawls in progress on SIGINT or SIGTERM") // Optional
+	cobraCmd.PersistentFlags().IntVar(&config.Global.ReadyPeriods, "web.ready-periods", 3, "Number of update periods after which /-/ready fails without a successful crawl") // Optional
+	cobraCmd.PersistentFlags().StringVarP(&config.Global.MetricsPath, "metrics", "m", "/metrics", "Path under which to expose metrics")         // Optional
+	cobraCmd.PersistentFlags().DurationVarP(&config.Global.MetricsUpdateRate, "rate", "r", 1*time.Second, "Set metrics update rate in seconds") // Optional
+	cobraCmd.PersistentFlags().DurationVar(&config.Global.MinRefreshInterval, "min-interval", 1*time.Second, "Minimum interval between two crawls of a Jenkins instance, faster rates and scrapes are slowed down to it") // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.CollectionMode, "mode", exporter.ModeCached, "Collection mode, one of: cached, scrape")     // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.QueueCollector, "collector.queue", false, "Enable the build queue metrics")                 // Optional
+	cobraCmd.PersistentFlags().BoolVar(&config.Global.NodesCollector, "collector.nodes", false, "Enable the node and executor metrics")          // Optional
+	cobraCmd.PersistentFlags().BoolVarP(&config.Global.Verbose, "verbose", "v", false, "Enable verbosity. Overrides log flag")                                      // Optional
+	cobraCmd.PersistentFlags().StringVar(&config.Global.LogLevel, "log", "info", "Log level, one of: info, debug, warn, error, fatal")          // Optional
+	cobraCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Path to a YAML or TOML configuration file") // Optional
+	cobraCmd.AddCommand(configCommand())
 	return &cobraCmd
 }
 
 func run(cmd *cobra.Command, args []string) {
+	if err := loadConfig(cmd.Flags()); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	ok := checkFlags()
 	if !ok {
 		fmt.Println("Use --help to get more info...")
 		os.Exit(1)
 	}
 	config.SetupLogging()
-	exporter.Serve()
+	if err := exporter.SetupTransport(); err != nil {
+		fmt.Println("Invalid TLS settings:", err)
+		os.Exit(1)
+	}
+	// Stop on SIGINT and SIGTERM, a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if err := exporter.Serve(ctx); err != nil {
+		logrus.Fatal(err)
+	}
 }
 
 func checkFlags() bool {
 	/* Check if mendatory flags are set */
 	// Check jenkins address
-	if config.Global.JenkinsAPIHostPort == "" {
+	if len(config.Global.Instances) == 0 {
 		fmt.Println("Jenkins host:port address is missing !")
 		return false
 	}
 
 	// Check if jenkins credentials are ok
-	if config.Global.JenkinsPassword == "" && config.Global.JenkinsToken == "" {
-		fmt.Println("Connecting to jenkins without credentials !")
-		config.Global.JenkinsWithCreds = false
+	for i := range config.Global.Instances {
+		instance := &config.Global.Instances[i]
+		if instance.Password == "" && instance.Token == "" {
+			fmt.Println("Connecting to jenkins " + instance.Name + " without credentials !")
+			instance.WithCreds = false
+		}
 	}
 
 	// If privileged port, check if user is root
@@ -89,6 +153,69 @@ func checkFlags() bool {
 		}
 	}
 
+	// Check collection mode
+	if !isCollectionMode(config.Global.CollectionMode) {
+		fmt.Println("The collection mode you provided is not supported, use one of: " + strings.Join(exporter.CollectionModes, ", "))
+		return false
+	}
+
+	if !isCrawlStrategy(config.Global.CrawlStrategy) {
+		fmt.Println("The crawl strategy you provided is not supported, use one of: " + strings.Join(exporter.CrawlStrategies, ", "))
+		return false
+	}
+
+	if config.Global.CrawlTreeDepth < 1 || config.Global.CrawlTreeMaxBytes < 1 || config.Global.MaxReplyBytes < 0 {
+		fmt.Println("The tree depth and maximum sizes you provided must be at least 1, or 0 for the maximum reply size")
+		return false
+	}
+
+	if config.Global.CrawlTreeDepth > exporter.MaxCrawlTreeDepth {
+		fmt.Printf("The tree depth you provided is above %d, the query would exceed the url size Jenkins accepts\n", exporter.MaxCrawlTreeDepth)
+		return false
+	}
+
+	if !isMetricStyle(config.Global.MetricStyle) {
+		fmt.Println("The metric style you provided is not supported, use one of: " + strings.Join(exporter.MetricStyles, ", "))
+		return false
+	}
+
+	if config.Global.TestReportTop < 0 {
+		fmt.Println("The number of test cases you provided can't be negative, use 0 to export none")
+		return false
+	}
+
+	if config.Global.HistoryBuilds < 1 {
+		fmt.Println("The number of builds fetched per job you provided must be at least 1")
+		return false
+	}
+
+	if !isIncreasing(config.Global.HistoryDurationBuckets) || !isIncreasing(config.Global.HistoryQueueBuckets) {
+		fmt.Println("The histogram buckets you provided must be in increasing order")
+		return false
+	}
+
+	if config.Global.RetryMax < 0 || config.Global.RetryBackoff <= 0 || config.Global.RetryMaxBackoff < config.Global.RetryBackoff {
+		fmt.Println("The retry settings you provided are invalid: the backoff must be positive and below the maximum backoff")
+		return false
+	}
+
+	if config.Global.LimitRate < 0 || config.Global.LimitBurst < 1 || config.Global.LimitInFlight < 0 {
+		fmt.Println("The limits you provided are invalid: the rate and the number of requests in flight can't be negative, the burst must be at least 1")
+		return false
+	}
+
+	if config.Global.ReadyPeriods < 1 {
+		fmt.Println("The number of readiness periods you provided must be at least 1")
+		return false
+	}
+
+	if config.Global.WebConfigFile != "" {
+		if err := web.Validate(config.Global.WebConfigFile); err != nil {
+			fmt.Println("The web configuration file you provided is invalid:", err)
+			return false
+		}
+	}
+
 	// Check log level
 	if _, ok := config.LogrusLevels[config.Global.LogLevel]; !ok {
 		fmt.Println("The log level you provided is not supported, using default - info")
@@ -97,3 +224,39 @@ func checkFlags() bool {
 
 	return true
 }
+
+func isMetricStyle(style string) bool {
+	for _, s := range exporter.MetricStyles {
+		if style == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isIncreasing(buckets []float64) bool {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func isCrawlStrategy(strategy string) bool {
+	for _, s := range exporter.CrawlStrategies {
+		if strategy == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isCollectionMode(mode string) bool {
+	for _, m := range exporter.CollectionModes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
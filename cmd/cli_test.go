@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestCheckFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		ok   bool
+	}{
+		{nil, true},
+		{[]string{"--history-builds", "1"}, true},
+		{[]string{"--history-builds", "0"}, false},
+		{[]string{"--history-builds", "-5"}, false},
+		{[]string{"--test-report-top", "0"}, true},
+		{[]string{"--test-report-top", "-1"}, false},
+		{[]string{"--history-duration-buckets", "10,5"}, false},
+	}
+	for _, tt := range tests {
+		setCredentialsEnv(t, "", "", "")
+		if _, err := load(t, "", "", append(tt.args, "--jenkins", "jenkins:8080")...); err != nil {
+			t.Fatal(err)
+		}
+		if got := checkFlags(); got != tt.ok {
+			t.Errorf("%v: got %v, want %v", tt.args, got, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file keys, and the flag each of them sets
+var configFlags = map[string]string{
+	"jenkins.address":                  "jenkins",
+	"jenkins.ssl":                      "ssl",
+	"jenkins.path":                     "path",
+	"jenkins.timeout":                  "timeout",
+	"jenkins.max_reply_bytes":          "max-reply-bytes",
+	"jenkins.tls.ca_file":              "tls.ca-file",
+	"jenkins.tls.cert_file":            "tls.cert-file",
+	"jenkins.tls.key_file":             "tls.key-file",
+	"jenkins.tls.min_version":          "tls.min-version",
+	"jenkins.tls.server_name":          "tls.server-name",
+	"jenkins.tls.insecure_skip_verify": "tls.insecure-skip-verify",
+	"jenkins.retry.max":                "retry.max",
+	"jenkins.retry.backoff":            "retry.backoff",
+	"jenkins.retry.max_backoff":        "retry.max-backoff",
+	"jenkins.breaker.failures":         "breaker.failures",
+	"jenkins.breaker.cooldown":         "breaker.cooldown",
+	"jenkins.limit.rate":               "limit.rate",
+	"jenkins.limit.burst":              "limit.burst",
+	"jenkins.limit.in_flight":          "limit.in-flight",
+	"crawl.workers":                    "workers",
+	"crawl.timeout":                    "crawl-timeout",
+	"crawl.idle_interval":              "crawl-idle-interval",
+	"crawl.strategy":                   "crawl-strategy",
+	"crawl.tree_depth":                 "crawl-tree-depth",
+	"crawl.tree_max_bytes":             "crawl-tree-max-bytes",
+	"web.listen":                       "listen",
+	"web.metrics_path":                 "metrics",
+	"web.config_file":                  "web.config.file",
+	"web.shutdown_timeout":             "web.shutdown-timeout",
+	"web.ready_periods":                "web.ready-periods",
+	"collection.rate":                  "rate",
+	"collection.min_interval":          "min-interval",
+	"collection.mode":                  "mode",
+	"collectors.queue":                 "collector.queue",
+	"collectors.nodes":                 "collector.nodes",
+	"metrics.stages":                   "stages",
+	"metrics.max_stages":               "max-stages",
+	"metrics.test_report":              "test-report",
+	"metrics.test_report_top":          "test-report-top",
+	"metrics.job_labels":               "job-labels",
+	"metrics.style":                    "metric-style",
+	"history.enabled":                  "history",
+	"history.builds":                   "history-builds",
+	"history.duration_buckets":         "history-duration-buckets",
+	"history.queue_buckets":            "history-queue-buckets",
+	"history.state_file":               "history-state-file",
+	"history.count_first":              "history-count-first",
+	"jobs.max_depth":                   "max-depth",
+	"jobs.skip_disabled":               "skip-disabled",
+	"log.level":                        "log",
+	"log.verbose":                      "verbose",
+}
+
+// Configuration file keys without flag
+const (
+	keyUsername           = "jenkins.username"
+	keyPassword           = "jenkins.password"
+	keyPasswordFile       = "jenkins.password_file"
+	keyToken              = "jenkins.token"
+	keyTokenFile          = "jenkins.token_file"
+	keyJobsInclude        = "jobs.include"
+	keyJobsExclude        = "jobs.exclude"
+	keyJobsIncludeRegex   = "jobs.include_regex"
+	keyJobsExcludeRegex   = "jobs.exclude_regex"
+	keyJobsIncludeClasses = "jobs.include_classes"
+	keyJobsExcludeClasses = "jobs.exclude_classes"
+	keyInstances          = "instances"
+	keyProbeTargets       = "probe.allowed_targets"
+	keyProbeModules       = "probe.modules"
+)
+
+var otherConfigKeys = []string{keyUsername, keyPassword, keyPasswordFile, keyToken, keyTokenFile, keyJobsInclude, keyJobsExclude,
+	keyJobsIncludeRegex, keyJobsExcludeRegex, keyJobsIncludeClasses, keyJobsExcludeClasses, keyInstances, keyProbeTargets}
+
+// Configuration file sections whose keys are checked when decoded
+var decodedConfigSections = []string{keyProbeModules + "."}
+
+// instanceConfig Jenkins instance of the configuration file, unset settings default to the flags ones
+type instanceConfig struct {
+	Name         string        `mapstructure:"name" yaml:"name"`
+	Address      string        `mapstructure:"address" yaml:"address"`
+	SSL          *bool         `mapstructure:"ssl" yaml:"ssl,omitempty"`
+	Timeout      time.Duration `mapstructure:"timeout" yaml:"timeout,omitempty"`
+	Rate         time.Duration `mapstructure:"rate" yaml:"rate,omitempty"`
+	Username     string        `mapstructure:"username" yaml:"username,omitempty"`
+	Password     string        `mapstructure:"password" yaml:"password,omitempty"`
+	PasswordFile string        `mapstructure:"password_file" yaml:"password_file,omitempty"`
+	Token        string        `mapstructure:"token" yaml:"token,omitempty"`
+	TokenFile    string        `mapstructure:"token_file" yaml:"token_file,omitempty"`
+	TLS          tlsConfig     `mapstructure:"tls" yaml:"tls,omitempty"`
+}
+
+// tlsConfig TLS settings of an instance or a probe module, unset settings default to the flags ones
+type tlsConfig struct {
+	CAFile     string `mapstructure:"ca_file" yaml:"ca_file,omitempty"`
+	CertFile   string `mapstructure:"cert_file" yaml:"cert_file,omitempty"`
+	KeyFile    string `mapstructure:"key_file" yaml:"key_file,omitempty"`
+	ServerName string `mapstructure:"server_name" yaml:"server_name,omitempty"`
+}
+
+// probeModuleConfig Probe module of the configuration file, unset settings default to the flags ones
+type probeModuleConfig struct {
+	Timeout      time.Duration `mapstructure:"timeout" yaml:"timeout,omitempty"`
+	Username     string        `mapstructure:"username" yaml:"username,omitempty"`
+	Password     string        `mapstructure:"password" yaml:"password,omitempty"`
+	PasswordFile string        `mapstructure:"password_file" yaml:"password_file,omitempty"`
+	Token        string        `mapstructure:"token" yaml:"token,omitempty"`
+	TokenFile    string        `mapstructure:"token_file" yaml:"token_file,omitempty"`
+	Collectors   struct {
+		Queue *bool `mapstructure:"queue" yaml:"queue,omitempty"`
+		Nodes *bool `mapstructure:"nodes" yaml:"nodes,omitempty"`
+	} `mapstructure:"collectors" yaml:"collectors"`
+	TLS tlsConfig `mapstructure:"tls" yaml:"tls,omitempty"`
+}
+
+// Keys whose values are never printed
+var secretConfigKeys = map[string]bool{keyPassword: true, keyToken: true}
+
+// Path of the configuration file, set by the --config flag
+var configFile string
+
+// loadConfig Merge the configuration file, the environment and the flags into config.Global.
+// Flags take precedence over the environment, which takes precedence over the file
+func loadConfig(flags *pflag.FlagSet) error {
+	viper.BindEnv(keyUsername, "JENKINS_USERNAME")
+	viper.BindEnv(keyPassword, "JENKINS_PASSWORD")
+	viper.BindEnv(keyToken, "JENKINS_TOKEN")
+
+	var lines []string
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("invalid configuration file %s: %w", configFile, err)
+		}
+		var err error
+		if lines, err = readLines(configFile); err != nil {
+			return err
+		}
+		if err := checkConfigKeys(lines); err != nil {
+			return err
+		}
+	}
+
+	// Set the flags that were not given on the command line from the file
+	for key, name := range configFlags {
+		flag := flags.Lookup(name)
+		if flag.Changed || !viper.InConfig(key) {
+			continue
+		}
+		value := viper.GetString(key)
+		if strings.HasSuffix(flag.Value.Type(), "Slice") {
+			value = strings.Join(viper.GetStringSlice(key), ",")
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %w", configFile+lineContext(lines, key), value, key, err)
+		}
+	}
+
+	if err := loadJobsFilter(lines); err != nil {
+		return err
+	}
+	config.Global.JenkinsUsername = viper.GetString(keyUsername)
+	var err error
+	if config.Global.JenkinsPassword, err = readSecret(viper.GetString(keyPassword), viper.GetString(keyPasswordFile)); err != nil {
+		return err
+	}
+	if config.Global.JenkinsToken, err = readSecret(viper.GetString(keyToken), viper.GetString(keyTokenFile)); err != nil {
+		return err
+	}
+	config.Global.JenkinsWithCreds = true
+	if err := loadInstances(lines); err != nil {
+		return err
+	}
+	return loadProbe(lines)
+}
+
+// Fill the job filtering rules of config.Global
+func loadJobsFilter(lines []string) error {
+	config.Global.JobsInclude = viper.GetStringSlice(keyJobsInclude)
+	config.Global.JobsExclude = viper.GetStringSlice(keyJobsExclude)
+	config.Global.JobsIncludeRegex = viper.GetStringSlice(keyJobsIncludeRegex)
+	config.Global.JobsExcludeRegex = viper.GetStringSlice(keyJobsExcludeRegex)
+	config.Global.JobsIncludeClasses = viper.GetStringSlice(keyJobsIncludeClasses)
+	config.Global.JobsExcludeClasses = viper.GetStringSlice(keyJobsExcludeClasses)
+	for _, patterns := range [][]string{config.Global.JobsInclude, config.Global.JobsExclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: invalid job pattern: %w", configFile+lineWithItem(lines, pattern), err)
+			}
+		}
+	}
+	for _, exprs := range [][]string{config.Global.JobsIncludeRegex, config.Global.JobsExcludeRegex} {
+		for _, expr := range exprs {
+			if _, err := regexp.Compile(expr); err != nil {
+				return fmt.Errorf("%s: invalid job regex: %w", configFile+lineWithItem(lines, expr), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Fill the probe settings of config.Global. Without modules, a default one without credentials is used
+func loadProbe(lines []string) error {
+	config.Global.ProbeTargets = viper.GetStringSlice(keyProbeTargets)
+	for _, target := range config.Global.ProbeTargets {
+		if _, err := regexp.Compile(target); err != nil {
+			return fmt.Errorf("%s: invalid allowed target: %w", configFile+lineWithItem(lines, target), err)
+		}
+	}
+
+	modules := make(map[string]probeModuleConfig)
+	err := viper.UnmarshalKey(keyProbeModules, &modules, func(dc *mapstructure.DecoderConfig) {
+		dc.ErrorUnused = true
+	})
+	if err != nil {
+		return fmt.Errorf("%s: invalid probe modules: %w", configFile+lineContext(lines, keyProbeModules), err)
+	}
+	if len(modules) == 0 {
+		modules["default"] = probeModuleConfig{}
+	}
+
+	config.Global.ProbeModules = make(map[string]config.ProbeModule, len(modules))
+	for name, mc := range modules {
+		module := config.ProbeModule{
+			Timeout:  config.Global.JenkinsAPITimeout,
+			Username: mc.Username,
+			Queue:    config.Global.QueueCollector,
+			Nodes:    config.Global.NodesCollector,
+			TLS:      instanceTLS(mc.TLS, false),
+		}
+		if mc.Timeout > 0 {
+			module.Timeout = mc.Timeout
+		}
+		if mc.Collectors.Queue != nil {
+			module.Queue = *mc.Collectors.Queue
+		}
+		if mc.Collectors.Nodes != nil {
+			module.Nodes = *mc.Collectors.Nodes
+		}
+		where := configFile + lineContext(lines, keyProbeModules+"."+name) + ": probe module " + name
+		if module.Password, err = readSecret(mc.Password, mc.PasswordFile); err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		if module.Token, err = readSecret(mc.Token, mc.TokenFile); err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		config.Global.ProbeModules[name] = module
+	}
+	return nil
+}
+
+// Fill config.Global.Instances from the instances of the file, or from the flags when there are none
+func loadInstances(lines []string) error {
+	var instances []instanceConfig
+	err := viper.UnmarshalKey(keyInstances, &instances, func(dc *mapstructure.DecoderConfig) {
+		dc.ErrorUnused = true
+	})
+	if err != nil {
+		return fmt.Errorf("%s: invalid instances: %w", configFile+lineContext(lines, keyInstances), err)
+	}
+
+	config.Global.Instances = nil
+	if len(instances) == 0 {
+		if config.Global.JenkinsAPIHostPort != "" {
+			config.Global.Instances = append(config.Global.Instances, config.Instance{
+				Name:       config.Global.JenkinsAPIHostPort,
+				SSLOn:      config.Global.SSLOn,
+				HostPort:   config.Global.JenkinsAPIHostPort,
+				Timeout:    config.Global.JenkinsAPITimeout,
+				UpdateRate: config.Global.MetricsUpdateRate,
+				Username:   config.Global.JenkinsUsername,
+				Password:   config.Global.JenkinsPassword,
+				Token:      config.Global.JenkinsToken,
+				WithCreds:  true,
+				Queue:      config.Global.QueueCollector,
+				Nodes:      config.Global.NodesCollector,
+				TLS:        instanceTLS(tlsConfig{}, true),
+			})
+		}
+		return nil
+	}
+
+	names := make(map[string]int, len(instances)) // Number of instances using the name
+	for i, ic := range instances {
+		context := lineContext(lines, keyInstances)
+		if ic.Name != "" {
+			context = lineWithValue(lines, keyInstances+".name", ic.Name, names[ic.Name])
+		}
+		where := fmt.Sprintf("%s: instance #%d", configFile+context, i+1)
+		switch {
+		case ic.Name == "":
+			return fmt.Errorf("%s: name is missing", where)
+		case names[ic.Name] > 0:
+			return fmt.Errorf("%s: name %s is used twice", where, ic.Name)
+		case ic.Address == "":
+			return fmt.Errorf("%s: address is missing", where)
+		}
+		names[ic.Name]++
+		instance := config.Instance{
+			Name:       ic.Name,
+			SSLOn:      config.Global.SSLOn,
+			HostPort:   ic.Address,
+			Timeout:    config.Global.JenkinsAPITimeout,
+			UpdateRate: config.Global.MetricsUpdateRate,
+			Username:   ic.Username,
+			WithCreds:  true,
+			Queue:      config.Global.QueueCollector,
+			Nodes:      config.Global.NodesCollector,
+			TLS:        instanceTLS(ic.TLS, true),
+		}
+		if ic.SSL != nil {
+			instance.SSLOn = *ic.SSL
+		}
+		if ic.Timeout > 0 {
+			instance.Timeout = ic.Timeout
+		}
+		if ic.Rate > 0 {
+			instance.UpdateRate = ic.Rate
+		}
+		if instance.Password, err = readSecret(ic.Password, ic.PasswordFile); err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		if instance.Token, err = readSecret(ic.Token, ic.TokenFile); err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		config.Global.Instances = append(config.Global.Instances, instance)
+	}
+	return nil
+}
+
+// instanceTLS Return the TLS settings of tc, defaulting to the flags ones. The server name of the flags
+// is only used by the instances, the probe targets are checked against their own host
+func instanceTLS(tc tlsConfig, withServerName bool) config.InstanceTLS {
+	t := config.InstanceTLS{
+		CAFile:   config.Global.TLSCAFile,
+		CertFile: config.Global.TLSCertFile,
+		KeyFile:  config.Global.TLSKeyFile,
+	}
+	if withServerName {
+		t.ServerName = config.Global.TLSServerName
+	}
+	if tc.CAFile != "" {
+		t.CAFile = tc.CAFile
+	}
+	// The certificate and its key go together
+	if tc.CertFile != "" || tc.KeyFile != "" {
+		t.CertFile, t.KeyFile = tc.CertFile, tc.KeyFile
+	}
+	if tc.ServerName != "" {
+		t.ServerName = tc.ServerName
+	}
+	return t
+}
+
+// Reject the keys of the file that are not known, they are most likely typos
+func checkConfigKeys(lines []string) error {
+	known := make(map[string]bool, len(configFlags)+len(otherConfigKeys))
+	for key := range configFlags {
+		known[key] = true
+	}
+	for _, key := range otherConfigKeys {
+		known[key] = true
+	}
+	for _, key := range viper.AllKeys() {
+		if !known[key] && !isDecodedConfigKey(key) && viper.InConfig(key) {
+			return fmt.Errorf("%s: unknown key %s", configFile+lineContext(lines, key), key)
+		}
+	}
+	return nil
+}
+
+func isDecodedConfigKey(key string) bool {
+	for _, section := range decodedConfigSections {
+		if strings.HasPrefix(key, section) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the secret given directly, or read from the file
+func readSecret(secret, file string) (string, error) {
+	if secret != "" || file == "" {
+		return secret, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret: %w", err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// Return ":<line>: <content>" for the line defining the key, empty if not found
+func lineContext(lines []string, key string) string {
+	return findConfigLine(lines, func(k, _ string) bool {
+		return k == key
+	})
+}
+
+// Return ":<line>: <content>" for the n-th line (from 0) setting key to value, empty if not found
+func lineWithValue(lines []string, key, value string, n int) string {
+	return findConfigLine(lines, func(k, v string) bool {
+		if k != key || strings.Trim(v, " \"'") != value {
+			return false
+		}
+		n--
+		return n < 0
+	})
+}
+
+var configKeyName = regexp.MustCompile(`^[\w.-]+$`)
+
+// findConfigLine Return ":<line>: <content>" for the first line whose full key and value match, empty if not found.
+// The full key follows the YAML indentation and the TOML [section] headers, the items of the lists
+// being keyed by their list, ex: the name of an instance is instances.name
+func findConfigLine(lines []string, match func(key, value string) bool) string {
+	type level struct {
+		indent int
+		key    string
+	}
+	var section string // TOML table
+	var levels []level // YAML mappings holding the line
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if strings.HasPrefix(trimmed, "[") {
+			section = strings.ToLower(strings.Trim(trimmed, "[] \"'"))
+			levels = nil
+			if match(section, "") {
+				return fmt.Sprintf(":%d: %s", i+1, trimmed)
+			}
+			continue
+		}
+		// The keys of a list item are nested in the item, below the key of the list
+		for strings.HasPrefix(trimmed, "-") {
+			rest := strings.TrimLeft(trimmed[1:], " ")
+			indent += len(trimmed) - len(rest)
+			trimmed = rest
+		}
+		sep := strings.IndexAny(trimmed, ":=")
+		if sep <= 0 {
+			continue
+		}
+		name := strings.ToLower(strings.Trim(trimmed[:sep], " \"'"))
+		// Not a key, but a list item like "https://jenkins"
+		if !configKeyName.MatchString(name) {
+			continue
+		}
+		for len(levels) > 0 && levels[len(levels)-1].indent >= indent {
+			levels = levels[:len(levels)-1]
+		}
+		parts := make([]string, 0, len(levels)+2)
+		if section != "" {
+			parts = append(parts, section)
+		}
+		for _, l := range levels {
+			parts = append(parts, l.key)
+		}
+		levels = append(levels, level{indent, name})
+		if match(strings.Join(append(parts, name), "."), strings.TrimSpace(trimmed[sep+1:])) {
+			return fmt.Sprintf(":%d: %s", i+1, strings.TrimSpace(line))
+		}
+	}
+	return ""
+}
+
+// Return the line number and content of the first list item with the value, empty if not found
+func lineWithItem(lines []string, value string) string {
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "-") && strings.Trim(trimmed[1:], " \"'") == value {
+			return fmt.Sprintf(":%d: %s", i+1, trimmed)
+		}
+	}
+	return ""
+}
+
+// configCommand Print the effective configuration
+func configCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "config",
+		Short: "Print the effective configuration, merged from the file, the environment and the flags",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := loadConfig(cmd.Flags()); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			out, err := yaml.Marshal(effectiveConfig(cmd.Flags()))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Print(string(out))
+		},
+	}
+}
+
+// Build the nested configuration from the flags and the keys without flag, secrets are redacted
+func effectiveConfig(flags *pflag.FlagSet) map[string]interface{} {
+	values := make(map[string]interface{})
+	for key, name := range configFlags {
+		values[key] = flagValue(flags.Lookup(name))
+	}
+	values[keyUsername] = config.Global.JenkinsUsername
+	values[keyPassword] = config.Global.JenkinsPassword
+	values[keyToken] = config.Global.JenkinsToken
+	values[keyJobsInclude] = config.Global.JobsInclude
+	values[keyJobsExclude] = config.Global.JobsExclude
+	values[keyJobsIncludeRegex] = config.Global.JobsIncludeRegex
+	values[keyJobsExcludeRegex] = config.Global.JobsExcludeRegex
+	values[keyJobsIncludeClasses] = config.Global.JobsIncludeClasses
+	values[keyJobsExcludeClasses] = config.Global.JobsExcludeClasses
+	values[keyInstances] = effectiveInstances()
+	values[keyProbeTargets] = config.Global.ProbeTargets
+	values[keyProbeModules] = effectiveProbeModules()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	effective := make(map[string]interface{})
+	for _, key := range keys {
+		value := values[key]
+		if secretConfigKeys[key] && value != "" {
+			value = "<redacted>"
+		}
+		parts := strings.Split(key, ".")
+		section := effective
+		for _, p := range parts[:len(parts)-1] {
+			if _, ok := section[p]; !ok {
+				section[p] = make(map[string]interface{})
+			}
+			section = section[p].(map[string]interface{})
+		}
+		section[parts[len(parts)-1]] = value
+	}
+	return effective
+}
+
+// Return the instances, secrets are redacted
+func effectiveInstances() []instanceConfig {
+	instances := make([]instanceConfig, 0, len(config.Global.Instances))
+	for _, instance := range config.Global.Instances {
+		ssl := instance.SSLOn
+		ic := instanceConfig{
+			Name:     instance.Name,
+			Address:  instance.HostPort,
+			SSL:      &ssl,
+			Timeout:  instance.Timeout,
+			Rate:     instance.UpdateRate,
+			Username: instance.Username,
+			TLS:      effectiveTLS(instance.TLS),
+		}
+		if instance.Password != "" {
+			ic.Password = "<redacted>"
+		}
+		if instance.Token != "" {
+			ic.Token = "<redacted>"
+		}
+		instances = append(instances, ic)
+	}
+	return instances
+}
+
+// Return the probe modules, secrets are redacted
+func effectiveProbeModules() map[string]probeModuleConfig {
+	modules := make(map[string]probeModuleConfig, len(config.Global.ProbeModules))
+	for name, module := range config.Global.ProbeModules {
+		queue, nodes := module.Queue, module.Nodes
+		mc := probeModuleConfig{
+			Timeout:  module.Timeout,
+			Username: module.Username,
+			TLS:      effectiveTLS(module.TLS),
+		}
+		mc.Collectors.Queue = &queue
+		mc.Collectors.Nodes = &nodes
+		if module.Password != "" {
+			mc.Password = "<redacted>"
+		}
+		if module.Token != "" {
+			mc.Token = "<redacted>"
+		}
+		modules[name] = mc
+	}
+	return modules
+}
+
+// effectiveTLS Return the TLS settings in the configuration file format
+func effectiveTLS(t config.InstanceTLS) tlsConfig {
+	return tlsConfig{CAFile: t.CAFile, CertFile: t.CertFile, KeyFile: t.KeyFile, ServerName: t.ServerName}
+}
+
+// Return the flag value with its type, for a readable output
+func flagValue(flag *pflag.Flag) interface{} {
+	switch flag.Value.Type() {
+	case "bool":
+		return flag.Value.String() == "true"
+	case "int", "int64":
+		var i int
+		fmt.Sscan(flag.Value.String(), &i)
+		return i
+	case "float64":
+		var f float64
+		fmt.Sscan(flag.Value.String(), &f)
+		return f
+	case "float64Slice":
+		var values []float64
+		for _, v := range flag.Value.(pflag.SliceValue).GetSlice() {
+			var f float64
+			fmt.Sscan(v, &f)
+			values = append(values, f)
+		}
+		return values
+	default:
+		return flag.Value.String()
+	}
+}
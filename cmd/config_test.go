@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// load Load the configuration file of the given content and extension with the command line args,
+// and return the flags. The global configuration is restored at the end of the test
+func load(t *testing.T, ext, content string, args ...string) (*pflag.FlagSet, error) {
+	savedConfig, savedFile := config.Global, configFile
+	t.Cleanup(func() {
+		config.Global, configFile = savedConfig, savedFile
+		viper.Reset()
+	})
+	viper.Reset()
+	cmd := RootCommand()
+	if content != "" {
+		path := filepath.Join(t.TempDir(), "config."+ext)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, "--config", path)
+	}
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Flags(), loadConfig(cmd.Flags())
+}
+
+// setCredentialsEnv Set the credentials environment variables for the test, empty ones being unset
+func setCredentialsEnv(t *testing.T, username, password, token string) {
+	t.Setenv("JENKINS_USERNAME", username)
+	t.Setenv("JENKINS_PASSWORD", password)
+	t.Setenv("JENKINS_TOKEN", token)
+}
+
+// writeSecret Write the secret to a file of the test directory and return its path
+func writeSecret(t *testing.T, secret string) string {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLineContext(t *testing.T) {
+	yamlLines := strings.Split(`jenkins:
+  timeout: 5s
+  tls:
+    ca_file: /etc/ca.pem
+# crawl settings
+crawl:
+  timeout: xyz
+instances:
+  - name: a
+    address: a:8080
+    tls:
+      ca_file: /etc/a.pem
+  - name: b
+    timeout: 5s
+probe:
+  allowed_targets:
+    - "https://jenkins:8080"
+  modules:
+    default:
+      timeout: 5s`, "\n")
+	tomlLines := strings.Split(`[jenkins]
+timeout = "5s"
+tls.ca_file = "/etc/ca.pem"
+
+[crawl]
+timeout = "xyz"
+
+[[instances]]
+name = "a"
+
+[probe.modules.default]
+timeout = "5s"`, "\n")
+	tests := []struct {
+		lines []string
+		key   string
+		want  int
+	}{
+		{yamlLines, "jenkins.timeout", 2},
+		{yamlLines, "crawl.timeout", 7},
+		{yamlLines, "crawl", 6},
+		{yamlLines, "jenkins.tls.ca_file", 4},
+		{yamlLines, "instances", 8},
+		{yamlLines, "instances.timeout", 14},
+		{yamlLines, "instances.tls.ca_file", 12},
+		{yamlLines, "probe.modules.default", 19},
+		{yamlLines, "probe.modules.default.timeout", 20},
+		{yamlLines, "crawl.workers", 0},
+		{tomlLines, "jenkins.timeout", 2},
+		{tomlLines, "crawl.timeout", 6},
+		{tomlLines, "jenkins.tls.ca_file", 3},
+		{tomlLines, "instances.name", 9},
+		{tomlLines, "probe.modules.default", 11},
+		{tomlLines, "probe.modules.default.timeout", 12},
+	}
+	for _, tt := range tests {
+		got := lineContext(tt.lines, tt.key)
+		want := ""
+		if tt.want > 0 {
+			want = fmt.Sprintf(":%d: %s", tt.want, strings.TrimSpace(tt.lines[tt.want-1]))
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", tt.key, got, want)
+		}
+	}
+
+	if got, want := lineWithValue(yamlLines, "instances.name", "b", 0), ":13: - name: b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := lineWithValue(yamlLines, "instances.name", "a", 1); got != "" {
+		t.Errorf("got %q for the second instance a, want none", got)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		ext     string
+		content string
+		want    string
+	}{
+		{"invalid value", "yml", "jenkins:\n  timeout: 5s\ncrawl:\n  timeout: xyz\n", ":4: timeout: xyz: invalid value \"xyz\" for crawl.timeout"},
+		{"invalid toml value", "toml", "[jenkins]\ntimeout = \"5s\"\n\n[crawl]\ntimeout = \"xyz\"\n", ":5: timeout = \"xyz\": invalid value"},
+		{"unknown key", "yml", "jenkins:\n  timeout: 5s\ncrawl:\n  timeuot: 5s\n", ":4: timeuot: 5s: unknown key crawl.timeuot"},
+		{"instance without address", "yml", "instances:\n  - name: a\n    address: a:8080\n  - name: b\n", ":4: - name: b: instance #2: address is missing"},
+		{"instance name used twice", "yml", "instances:\n  - name: a\n    address: a:8080\n  - name: a\n    address: b:8080\n", ":4: - name: a: instance #2: name a is used twice"},
+		{"invalid probe module", "yml", "probe:\n  modules:\n    default:\n      timeuot: 5s\n", ":2: modules:: invalid probe modules"},
+		{"invalid job regex", "yml", "jobs:\n  include_regex:\n    - \"team-(a\"\n", ":3: - \"team-(a\": invalid job regex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.ext, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := `jenkins:
+  address: file:8080
+  timeout: 5s
+  username: file-user
+  token: file-token
+crawl:
+  workers: 8
+history:
+  duration_buckets: [1, 2, 3]
+`
+	t.Run("file", func(t *testing.T) {
+		setCredentialsEnv(t, "", "", "")
+		if _, err := load(t, "yml", file); err != nil {
+			t.Fatal(err)
+		}
+		if config.Global.JenkinsAPIHostPort != "file:8080" || config.Global.JenkinsAPITimeout != 5*time.Second || config.Global.CrawlWorkers != 8 {
+			t.Errorf("got address %s, timeout %v and %d workers, want the file ones",
+				config.Global.JenkinsAPIHostPort, config.Global.JenkinsAPITimeout, config.Global.CrawlWorkers)
+		}
+		if !reflect.DeepEqual(config.Global.HistoryDurationBuckets, []float64{1, 2, 3}) {
+			t.Errorf("got buckets %v, want the file ones", config.Global.HistoryDurationBuckets)
+		}
+		if config.Global.JenkinsUsername != "file-user" || config.Global.JenkinsToken != "file-token" {
+			t.Errorf("got credentials %s/%s, want the file ones", config.Global.JenkinsUsername, config.Global.JenkinsToken)
+		}
+		// Not in the file
+		if config.Global.MetricsUpdateRate != time.Second {
+			t.Errorf("got rate %v, want the default of the flag", config.Global.MetricsUpdateRate)
+		}
+	})
+	t.Run("environment over file", func(t *testing.T) {
+		setCredentialsEnv(t, "env-user", "", "env-token")
+		if _, err := load(t, "yml", file); err != nil {
+			t.Fatal(err)
+		}
+		if config.Global.JenkinsUsername != "env-user" || config.Global.JenkinsToken != "env-token" {
+			t.Errorf("got credentials %s/%s, want the environment ones", config.Global.JenkinsUsername, config.Global.JenkinsToken)
+		}
+	})
+	t.Run("flags over file", func(t *testing.T) {
+		if _, err := load(t, "yml", file, "--timeout", "7s", "--workers", "2"); err != nil {
+			t.Fatal(err)
+		}
+		if config.Global.JenkinsAPITimeout != 7*time.Second || config.Global.CrawlWorkers != 2 {
+			t.Errorf("got timeout %v and %d workers, want the flags ones", config.Global.JenkinsAPITimeout, config.Global.CrawlWorkers)
+		}
+		if config.Global.JenkinsAPIHostPort != "file:8080" {
+			t.Errorf("got address %s, want the file one", config.Global.JenkinsAPIHostPort)
+		}
+	})
+}
+
+func TestLoadInstances(t *testing.T) {
+	setCredentialsEnv(t, "", "", "")
+	token := writeSecret(t, "b-token")
+	content := `jenkins:
+  timeout: 5s
+  ssl: true
+  tls:
+    ca_file: /etc/ca.pem
+    server_name: jenkins.internal
+collection:
+  rate: 30s
+instances:
+  - name: a
+    address: a:8080
+  - name: b
+    address: b:8443
+    ssl: false
+    timeout: 20s
+    rate: 1m
+    username: b-user
+    token_file: ` + token + `
+    tls:
+      ca_file: /etc/b.pem
+`
+	if _, err := load(t, "yml", content); err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Instance{
+		{
+			Name: "a", SSLOn: true, HostPort: "a:8080", Timeout: 5 * time.Second, UpdateRate: 30 * time.Second, WithCreds: true,
+			TLS: config.InstanceTLS{CAFile: "/etc/ca.pem", ServerName: "jenkins.internal"},
+		},
+		{
+			Name: "b", SSLOn: false, HostPort: "b:8443", Timeout: 20 * time.Second, UpdateRate: time.Minute, WithCreds: true,
+			Username: "b-user", Token: "b-token",
+			TLS: config.InstanceTLS{CAFile: "/etc/b.pem", ServerName: "jenkins.internal"},
+		},
+	}
+	if !reflect.DeepEqual(config.Global.Instances, want) {
+		t.Errorf("got instances\n%+v\nwant\n%+v", config.Global.Instances, want)
+	}
+
+	// Without instances, the flags define a single one
+	if _, err := load(t, "yml", "", "--jenkins", "jenkins:8080", "--rate", "10s"); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Global.Instances) != 1 || config.Global.Instances[0].HostPort != "jenkins:8080" || config.Global.Instances[0].UpdateRate != 10*time.Second {
+		t.Errorf("got instances %+v, want the one of the flags", config.Global.Instances)
+	}
+}
+
+func TestEffectiveConfigRedactsSecrets(t *testing.T) {
+	content := `jenkins:
+  address: jenkins:8080
+  username: admin
+  password: top-secret-password
+  token_file: ` + writeSecret(t, "top-secret-token") + `
+instances:
+  - name: a
+    address: a:8080
+    password: top-secret-a
+probe:
+  modules:
+    default:
+      token: top-secret-probe
+`
+	setCredentialsEnv(t, "", "", "")
+	flags, err := load(t, "yml", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := yaml.Marshal(effectiveConfig(flags))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "top-secret") {
+		t.Errorf("secret printed in the effective configuration:\n%s", out)
+	}
+	if got := strings.Count(string(out), "<redacted>"); got != 4 {
+		t.Errorf("got %d redacted values, want the 4 secrets:\n%s", got, out)
+	}
+	if !strings.Contains(string(out), "username: admin") {
+		t.Errorf("username missing from the effective configuration:\n%s", out)
+	}
+}
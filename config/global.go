@@ -8,19 +8,105 @@ import (
 
 // Config Global configuration for the jenkins exporter
 type Config struct {
-	SSLOn              bool
-	JenkinsAPIHostPort string
-	JenkinsAPIPath     string
-	JenkinsAPITimeout  time.Duration
-	JenkinsUsername    string
-	JenkinsPassword    string
-	JenkinsToken       string
-	JenkinsWithCreds   bool
-	ExporterHostPort   string
-	MetricsPath        string
-	MetricsUpdateRate  time.Duration
-	Verbose            bool
-	LogLevel           string
+	SSLOn                  bool
+	JenkinsAPIHostPort     string
+	JenkinsAPIPath         string
+	JenkinsAPITimeout      time.Duration
+	MaxReplyBytes          int64
+	TLSCAFile              string
+	TLSCertFile            string
+	TLSKeyFile             string
+	TLSMinVersion          string
+	TLSServerName          string
+	TLSInsecureSkipVerify  bool
+	RetryMax               int
+	RetryBackoff           time.Duration
+	RetryMaxBackoff        time.Duration
+	BreakerFailures        int
+	BreakerCooldown        time.Duration
+	LimitRate              float64
+	LimitBurst             int
+	LimitInFlight          int
+	MinRefreshInterval     time.Duration
+	CrawlWorkers           int
+	CrawlTimeout           time.Duration
+	CrawlIdleInterval      time.Duration
+	CrawlStrategy          string
+	CrawlTreeDepth         int
+	CrawlTreeMaxBytes      int64
+	PipelineStages         bool
+	MaxStages              int
+	TestReport             bool
+	TestReportTop          int
+	JobLabels              bool
+	MetricStyle            string
+	BuildHistory           bool
+	HistoryBuilds          int
+	HistoryDurationBuckets []float64
+	HistoryQueueBuckets    []float64
+	HistoryStateFile       string
+	HistoryCountFirst      bool
+	JenkinsUsername        string
+	JenkinsPassword        string
+	JenkinsToken           string
+	JenkinsWithCreds       bool
+	JobsInclude            []string
+	JobsExclude            []string
+	JobsIncludeRegex       []string
+	JobsExcludeRegex       []string
+	JobsIncludeClasses     []string
+	JobsExcludeClasses     []string
+	JobsMaxDepth           int
+	JobsSkipDisabled       bool
+	Instances              []Instance
+	ProbeTargets           []string
+	ProbeModules           map[string]ProbeModule
+	ExporterHostPort       string
+	WebConfigFile          string
+	ShutdownTimeout        time.Duration
+	ReadyPeriods           int
+	MetricsPath            string
+	MetricsUpdateRate      time.Duration
+	CollectionMode         string
+	QueueCollector         bool
+	NodesCollector         bool
+	Verbose                bool
+	LogLevel               string
+}
+
+// Instance Settings of a monitored Jenkins instance
+type Instance struct {
+	Name       string
+	SSLOn      bool
+	HostPort   string
+	Timeout    time.Duration
+	UpdateRate time.Duration
+	Username   string
+	Password   string
+	Token      string
+	WithCreds  bool
+	Queue      bool
+	Nodes      bool
+	TLS        InstanceTLS
+}
+
+// InstanceTLS TLS settings of an instance, the minimum version and the verification are global
+type InstanceTLS struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// ProbeModule Settings used to probe a Jenkins target
+type ProbeModule struct {
+	Timeout  time.Duration
+	Username string
+	Password string
+	Token    string
+	Queue    bool
+	Nodes    bool
+	TLS      InstanceTLS
 }
 
 // Global The Global variable instance
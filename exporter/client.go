@@ -0,0 +1,343 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Jenkins crumb issuer response struct
+type jCrumb struct {
+	Field string `json:"crumbRequestField"`
+	Value string `json:"crumb"`
+}
+
+// Message of the 403 replies to requests without a valid crumb
+const invalidCrumbMessage = "No valid crumb was included in the request"
+
+// Client HTTP client of a Jenkins instance, shared by all its requests.
+// It keeps the session cookies, as crumbs are only valid within the session
+// that fetched them, and adds the CSRF crumb to the requests that modify data.
+// Failed reads are retried, and a circuit breaker stops the requests while Jenkins is down
+type Client struct {
+	instance *config.Instance
+	http     *http.Client
+	limiter  *limiter
+	breaker  *breaker
+	retries  *prometheus.CounterVec
+
+	mu            sync.Mutex
+	crumb         *jCrumb       // Nil until fetched
+	crumbFetch    chan struct{} // Closed once the crumb being fetched is known, nil when no fetch is in progress
+	crumbDisabled bool          // The crumb issuer is disabled, requests are sent without crumb
+	crumbOnReads  bool          // Reads were refused without crumb, by Jenkins or a proxy in front of it
+}
+
+// NewClient Create the client of the Jenkins instance
+func NewClient(instance *config.Instance) *Client {
+	// The jar can't fail without options
+	jar, _ := cookiejar.New(nil)
+	c := &Client{
+		instance: instance,
+		retries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "jenkins_request_retries_total",
+				Help: "Number of requests to Jenkins sent again by reason",
+			},
+			[]string{
+				"reason",
+			},
+		),
+	}
+	for _, reason := range retryReasons {
+		c.retries.WithLabelValues(reason)
+	}
+	c.limiter = limiterFor(c.BaseURL())
+	c.breaker = breakerFor(c.BaseURL(), instance.Name)
+	transport, err := transportFor(instance)
+	if err != nil {
+		// Checked at startup, unless the files changed since
+		logrus.Error("Invalid TLS settings of ", instance.Name, ": ", err)
+		transport = failingTransport{err: err}
+	}
+	c.http = &http.Client{Timeout: instance.Timeout, Jar: jar, Transport: transport}
+	return c
+}
+
+var (
+	breakerStateDesc = prometheus.NewDesc(
+		"jenkins_circuit_breaker_state",
+		"State of the circuit breaker of the requests to Jenkins, 1 for the current one",
+		[]string{"state"}, nil,
+	)
+	breakerOpenedDesc = prometheus.NewDesc(
+		"jenkins_circuit_breaker_opened_total",
+		"Number of times the circuit breaker of the requests to Jenkins opened",
+		nil, nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (c *Client) Describe(ch chan<- *prometheus.Desc) {
+	c.retries.Describe(ch)
+	c.limiter.Describe(ch)
+	ch <- breakerStateDesc
+	ch <- breakerOpenedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *Client) Collect(ch chan<- prometheus.Metric) {
+	c.retries.Collect(ch)
+	c.limiter.Collect(ch)
+	c.breaker.mu.Lock()
+	state := c.breaker.state()
+	opened := c.breaker.opened
+	c.breaker.mu.Unlock()
+	for _, s := range breakerStates {
+		ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, b2F64(s == state), s)
+	}
+	ch <- prometheus.MustNewConstMetric(breakerOpenedDesc, prometheus.CounterValue, float64(opened))
+}
+
+// BaseURL Return the root url of Jenkins, ending with /
+func (c *Client) BaseURL() string {
+	var apiurl string = "http://"
+	if c.instance.SSLOn {
+		apiurl = "https://"
+	}
+	apiurl += c.instance.HostPort + "/"
+	return apiurl
+}
+
+// Do Send a request to Jenkins with the given headers, and return the response when its status is below 400, else a *JenkinsError.
+// Requests other than GET and HEAD carry the crumb. Requests refused for their crumb are sent once more,
+// with a new crumb when it expired. GET and HEAD requests failing on a connection error, a 429 or a 5xx
+// are retried up to config.Global.RetryMax times, see retryDelay
+func (c *Client) Do(ctx context.Context, method, url string, header http.Header, body []byte) (*http.Response, error) {
+	retries := 0
+	if method == http.MethodGet || method == http.MethodHead {
+		retries = config.Global.RetryMax
+	}
+	for attempt := 0; ; attempt++ {
+		trial, err := c.breaker.allow()
+		if err != nil {
+			return nil, &JenkinsError{Kind: ErrUnavailable, URL: url, Err: err}
+		}
+		resp, err := c.try(ctx, method, url, header, body)
+		if errors.Is(err, errLimited) {
+			c.breaker.record(trial, breakerIgnored)
+			return nil, err
+		}
+		reason := retryReason(resp, err)
+		switch {
+		case ctx.Err() != nil:
+			c.breaker.record(trial, breakerIgnored)
+		case reason != "":
+			c.breaker.record(trial, breakerFailure)
+		default:
+			c.breaker.record(trial, breakerSuccess)
+		}
+		if reason == "" || attempt >= retries || ctx.Err() != nil {
+			return c.result(url, resp, err)
+		}
+		delay := retryDelay(attempt, resp)
+		if deadline, ok := ctx.Deadline(); delay > config.Global.RetryMaxBackoff || ok && time.Until(deadline) < delay {
+			logrus.Debug("Not retrying ", url, ", Jenkins asked to wait ", delay)
+			return c.result(url, resp, err)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		logrus.Debug("Retrying ", url, " in ", delay, " after a ", reason, " error")
+		c.retries.WithLabelValues(reason).Inc()
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, transportError(url, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Return the response of the last attempt when its status is below 400, else a *JenkinsError
+func (c *Client) result(url string, resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	// Control the response code
+	logrus.Debug("Request HTTP response code ", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, statusError(url, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// try Send the request, once more when it is refused for its crumb
+func (c *Client) try(ctx context.Context, method, url string, header http.Header, body []byte) (*http.Response, error) {
+	c.mu.Lock()
+	withCrumb := method != http.MethodGet && method != http.MethodHead || c.crumbOnReads
+	c.mu.Unlock()
+	resp, err := c.send(ctx, method, url, header, body, withCrumb)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusForbidden && isInvalidCrumb(resp) {
+		c.mu.Lock()
+		if withCrumb {
+			logrus.Debug("Invalid crumb for ", url, ", fetching a new one")
+			c.crumb = nil
+		} else {
+			logrus.Debug("Crumb required for ", url, ", sending it from now on")
+			c.crumbOnReads = true
+		}
+		c.mu.Unlock()
+		return c.send(ctx, method, url, header, body, true)
+	}
+	return resp, nil
+}
+
+func (c *Client) send(ctx context.Context, method, url string, header http.Header, body []byte, withCrumb bool) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	// Init a http request, set basic auth and Do the request
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, &JenkinsError{Kind: ErrServer, URL: url, Err: err}
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	c.setAuth(req)
+	if withCrumb {
+		crumb, err := c.getCrumb(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if crumb != nil {
+			req.Header.Set(crumb.Field, crumb.Value)
+		}
+	}
+	return c.do(url, req)
+}
+
+// do Send the request once allowed by the limiter. The wait isn't part of the timeout of the instance,
+// which starts with the request. A request is in flight until its body is closed
+func (c *Client) do(url string, req *http.Request) (*http.Response, error) {
+	release, err := c.limiter.acquire(req.Context())
+	if err != nil {
+		return nil, &JenkinsError{Kind: ErrTimeout, URL: url, Err: err}
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		release()
+		return nil, transportError(url, err)
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+func (c *Client) setAuth(req *http.Request) {
+	// Test if credentials are used
+	if c.instance.WithCreds {
+		if c.instance.Password != "" {
+			req.SetBasicAuth(c.instance.Username, c.instance.Password)
+		}
+		if c.instance.Token != "" {
+			req.SetBasicAuth(c.instance.Username, c.instance.Token)
+		}
+	}
+}
+
+// getCrumb Return the crumb of the session, fetched on first use. Nil when the crumb issuer is disabled.
+// The crumb is fetched by a single request at a time, without holding c.mu so that the other requests
+// aren't blocked by a slow crumb issuer
+func (c *Client) getCrumb(ctx context.Context) (*jCrumb, error) {
+	for {
+		c.mu.Lock()
+		if c.crumb != nil || c.crumbDisabled {
+			crumb := c.crumb
+			c.mu.Unlock()
+			return crumb, nil
+		}
+		fetching := c.crumbFetch
+		if fetching == nil {
+			done := make(chan struct{})
+			c.crumbFetch = done
+			c.mu.Unlock()
+			crumb, disabled, err := c.fetchCrumb(ctx)
+			c.mu.Lock()
+			if err == nil {
+				c.crumb, c.crumbDisabled = crumb, disabled
+			}
+			c.crumbFetch = nil
+			c.mu.Unlock()
+			close(done)
+			return crumb, err
+		}
+		c.mu.Unlock()
+		// Fetched again by the next request when the fetch in progress fails
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, transportError(c.BaseURL()+"crumbIssuer/api/json", ctx.Err())
+		}
+	}
+}
+
+// fetchCrumb Request a crumb from the crumb issuer, disabled is true when there is none
+func (c *Client) fetchCrumb(ctx context.Context) (crumb *jCrumb, disabled bool, err error) {
+	url := c.BaseURL() + "crumbIssuer/api/json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, &JenkinsError{Kind: ErrServer, URL: url, Err: err}
+	}
+	c.setAuth(req)
+	resp, err := c.do(url, req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		logrus.Debug("No crumb issuer on ", c.instance.Name, ", sending requests without crumb")
+		return nil, true, nil
+	}
+	if resp.StatusCode >= 400 {
+		return nil, false, statusError(url, resp.StatusCode)
+	}
+	crumb = &jCrumb{}
+	if err := json.NewDecoder(resp.Body).Decode(crumb); err != nil {
+		return nil, false, &JenkinsError{Kind: ErrDecode, URL: url, Err: err}
+	}
+	return crumb, false, nil
+}
+
+// Check if a 403 reply is due to a missing or expired crumb, the body is closed when it is
+func isInvalidCrumb(resp *http.Response) bool {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil || !strings.Contains(string(body), invalidCrumbMessage) {
+		// Give the part read back to the caller
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
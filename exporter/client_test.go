@@ -0,0 +1,204 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+)
+
+// crumbJenkins Fake Jenkins checking the crumbs of the requests against the one issued in the session.
+// The crumb issuer answers once release is closed
+type crumbJenkins struct {
+	mu       sync.Mutex
+	issued   int  // Number of crumbs issued
+	disabled bool // The crumb issuer answers 404
+	onReads  bool // GET requests need a crumb too
+	release  chan struct{}
+}
+
+func (j *crumbJenkins) crumb() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return fmt.Sprint("crumb-", j.issued)
+}
+
+// expire Make the crumb of the session invalid
+func (j *crumbJenkins) expire() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.issued++
+}
+
+func (j *crumbJenkins) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/crumbIssuer/api/json" {
+		if j.release != nil {
+			<-j.release
+		}
+		if j.disabled {
+			http.NotFound(w, r)
+			return
+		}
+		j.mu.Lock()
+		j.issued++
+		j.mu.Unlock()
+		// The crumb is only valid within the session
+		http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: j.crumb(), Path: "/"})
+		fmt.Fprintf(w, `{"crumbRequestField":"Jenkins-Crumb","crumb":%q}`, j.crumb())
+		return
+	}
+	if r.Method != http.MethodGet || j.onReads {
+		session, err := r.Cookie("JSESSIONID")
+		valid := j.disabled && r.Header.Get("Jenkins-Crumb") == "" ||
+			err == nil && session.Value == j.crumb() && r.Header.Get("Jenkins-Crumb") == j.crumb()
+		if !valid {
+			http.Error(w, invalidCrumbMessage, http.StatusForbidden)
+			return
+		}
+	}
+	w.Write([]byte(`{}`))
+}
+
+func post(c *Client) error {
+	resp, err := c.Do(context.Background(), http.MethodPost, c.BaseURL()+"job/app/build", nil, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestCrumb(t *testing.T) {
+	setTestConfig(t, nil)
+	jenkins := &crumbJenkins{}
+	f := newFakeJenkins(t, jenkins)
+	c := NewClient(f.instance())
+
+	for i := 0; i < 2; i++ {
+		if err := post(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := f.count("/crumbIssuer/api/json"); got != 1 {
+		t.Errorf("crumb fetched %d times, want once for the session", got)
+	}
+	if got := f.count("/job/app/build"); got != 2 {
+		t.Errorf("got %d requests, want 2 without refusal", got)
+	}
+	// Reads don't need a crumb
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired crumb, the request is sent again with a new one
+	jenkins.expire()
+	if err := post(c); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.count("/crumbIssuer/api/json"); got != 2 {
+		t.Errorf("crumb fetched %d times, want once more after the refusal", got)
+	}
+	if got := f.count("/job/app/build"); got != 4 {
+		t.Errorf("got %d requests, want the refused one and its retry", got)
+	}
+}
+
+func TestCrumbOnReads(t *testing.T) {
+	setTestConfig(t, nil)
+	f := newFakeJenkins(t, &crumbJenkins{onReads: true})
+	c := NewClient(f.instance())
+	for i := 0; i < 2; i++ {
+		if err := get(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Refused once without crumb, then sent with it
+	if got := f.count("/api/json"); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+}
+
+func TestCrumbDisabled(t *testing.T) {
+	setTestConfig(t, nil)
+	f := newFakeJenkins(t, &crumbJenkins{disabled: true})
+	c := NewClient(f.instance())
+	for i := 0; i < 2; i++ {
+		if err := post(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := f.count("/crumbIssuer/api/json"); got != 1 {
+		t.Errorf("crumb issuer requested %d times, want once", got)
+	}
+}
+
+func TestCrumbSlowIssuer(t *testing.T) {
+	setTestConfig(t, nil)
+	jenkins := &crumbJenkins{release: make(chan struct{})}
+	f := newFakeJenkins(t, jenkins)
+	c := NewClient(f.instance())
+
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- post(c)
+		}()
+	}
+	for f.count("/crumbIssuer/api/json") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The other requests aren't blocked by the crumb being fetched
+	done := make(chan error, 1)
+	go func() {
+		done <- get(c)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("read blocked by the crumb fetch")
+	}
+
+	close(jenkins.release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if got := f.count("/crumbIssuer/api/json"); got != 1 {
+		t.Errorf("crumb fetched %d times, want once for the concurrent requests", got)
+	}
+}
+
+func TestCrumbFetchCancelled(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.JenkinsAPITimeout = time.Second
+	})
+	jenkins := &crumbJenkins{release: make(chan struct{})}
+	f := newFakeJenkins(t, jenkins)
+	c := NewClient(f.instance())
+	fetched := make(chan struct{})
+	go func() {
+		post(c)
+		close(fetched)
+	}()
+	// The request fetching the crumb ends before the configuration is restored
+	defer func() {
+		close(jenkins.release)
+		<-fetched
+	}()
+	for f.count("/crumbIssuer/api/json") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Waiting for the fetch of another request stops with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(ctx, http.MethodPost, c.BaseURL()+"job/app/build", nil, nil); errorKindOf(err) != ErrTimeout {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,282 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Collection modes
+const (
+	// ModeCached Serve the latest snapshot crawled by a background refresher
+	ModeCached = "cached"
+	// ModeScrape Crawl Jenkins on each scrape
+	ModeScrape = "scrape"
+)
+
+// CollectionModes Supported collection modes
+var CollectionModes = []string{ModeCached, ModeScrape}
+
+// source Part of the Jenkins API crawled by the JenkinsCollector.
+// fetch replaces the snapshot served by Collect, and keeps it untouched on error
+type source interface {
+	prometheus.Collector
+	name() string
+	fetch(ctx context.Context) error
+}
+
+// JenkinsCollector Prometheus collector exposing the data of a Jenkins instance
+type JenkinsCollector struct {
+	instance *config.Instance
+	sources  []source
+	jobs     *jobsSource
+	client   *Client
+
+	refreshMu sync.Mutex // Serializes the crawls
+	crawl     crawlStatus
+
+	up                   prometheus.Gauge
+	scrapeErrors         *prometheus.CounterVec
+	lastSuccessfulScrape prometheus.Gauge
+}
+
+// NewJenkinsCollector Create a collector of the Jenkins instance, with the build histograms when history isn't nil
+func NewJenkinsCollector(instance *config.Instance, history *HistoryStore) *JenkinsCollector {
+	initMetricsOnce.Do(initJobMetrics)
+	api := newJenkinsAPI(instance)
+	jobs := &jobsSource{api: api, filter: newJobFilter(), cache: newFolderCache()}
+	if history != nil {
+		jobs.history = newBuildHistory(history, instance.Name)
+	}
+	c := &JenkinsCollector{
+		instance: instance,
+		sources:  []source{jobs},
+		jobs:     jobs,
+		client:   api.client,
+		up: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_up",
+				Help: "Whether the last crawl of Jenkins was successful",
+			},
+		),
+		scrapeErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "jenkins_scrape_errors_total",
+				Help: "Number of failed crawls of Jenkins by error kind",
+			},
+			[]string{
+				"kind",
+			},
+		),
+		lastSuccessfulScrape: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_last_successful_scrape_timestamp_seconds",
+				Help: "Unixtime of the last successful crawl of Jenkins",
+			},
+		),
+	}
+	if instance.Queue {
+		c.sources = append(c.sources, &queueSource{api: api})
+	}
+	if instance.Nodes {
+		c.sources = append(c.sources, &nodesSource{api: api})
+	}
+	// Expose every error kind from the start so that rate() works on the first error
+	for _, kind := range errorKinds {
+		c.scrapeErrors.With(prometheus.Labels{"kind": string(kind)})
+	}
+	return c
+}
+
+// Describe implements prometheus.Collector
+func (c *JenkinsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, s := range c.sources {
+		s.Describe(ch)
+	}
+	c.client.Describe(ch)
+	c.up.Describe(ch)
+	c.scrapeErrors.Describe(ch)
+	c.lastSuccessfulScrape.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *JenkinsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.sources {
+		s.Collect(ch)
+	}
+	c.client.Collect(ch)
+	c.up.Collect(ch)
+	c.scrapeErrors.Collect(ch)
+	c.lastSuccessfulScrape.Collect(ch)
+}
+
+// Refresh Crawl every source concurrently. A failing source keeps its last good snapshot.
+// Within config.Global.MinRefreshInterval of the end of the previous crawl, Jenkins isn't crawled
+// again and the outcome of the previous crawl is returned
+func (c *JenkinsCollector) Refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	c.crawl.mu.Lock()
+	lastEnd := c.crawl.lastCrawl.Add(c.crawl.duration)
+	lastErr := c.crawl.lastError
+	c.crawl.mu.Unlock()
+	if time.Since(lastEnd) < config.Global.MinRefreshInterval {
+		logrus.Debug("Jenkins ", c.instance.Name, " crawled less than ", config.Global.MinRefreshInterval, " ago, keeping the snapshot")
+		return lastErr
+	}
+	start := time.Now()
+	errs := make([]error, len(c.sources))
+	var wg sync.WaitGroup
+	for i, s := range c.sources {
+		wg.Add(1)
+		go func(i int, s source) {
+			defer wg.Done()
+			errs[i] = s.fetch(ctx)
+		}(i, s)
+	}
+	wg.Wait()
+	// A cancelled crawl tells nothing about Jenkins, the snapshot and jenkins_up are left as they are
+	if ctx.Err() == context.Canceled {
+		logrus.Debug("Crawl of Jenkins ", c.instance.Name, " cancelled")
+		return ctx.Err()
+	}
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		logrus.Error("Failed to get ", c.sources[i].name(), " from Jenkins ", c.instance.Name, ": ", err)
+		c.scrapeErrors.With(prometheus.Labels{"kind": string(errorKindOf(err))}).Inc()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.crawl.record(start, firstErr)
+	if firstErr != nil {
+		c.up.Set(0)
+		return firstErr
+	}
+	c.up.Set(1)
+	c.lastSuccessfulScrape.SetToCurrentTime()
+	return nil
+}
+
+// Run Refresh the snapshot periodically until ctx is done, used by the cached mode.
+// The crawl in progress is cancelled along with ctx
+func (c *JenkinsCollector) Run(ctx context.Context) {
+	rate := c.updateRate()
+	if rate != c.instance.UpdateRate {
+		logrus.Warn("Update rate of ", c.instance.Name, " below the minimum interval, using ", rate)
+	}
+	logrus.Debug("Launching metrics update loop of ", c.instance.Name, ": updating rate is set to ", rate)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Debug("Metrics update loop of ", c.instance.Name, " stopped")
+			return
+		case <-timer.C:
+		}
+		c.Refresh(ctx)
+		timer.Reset(rate)
+	}
+}
+
+// updateRate Return the update rate of the instance, slowed down to config.Global.MinRefreshInterval
+func (c *JenkinsCollector) updateRate() time.Duration {
+	if c.instance.UpdateRate < config.Global.MinRefreshInterval {
+		return config.Global.MinRefreshInterval
+	}
+	return c.instance.UpdateRate
+}
+
+// jobsSource Jobs found by crawling the Jenkins folders
+type jobsSource struct {
+	api     *jenkinsAPI
+	filter  *jobFilter
+	history *buildHistory // Nil without --history
+	cache   *folderCache
+	mu      sync.RWMutex
+	jobs    *[]job
+}
+
+func (s *jobsSource) name() string {
+	return "jobs"
+}
+
+func (s *jobsSource) fetch(ctx context.Context) error {
+	jobs, err := s.api.GetData(ctx, s.filter, s.cache)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.jobs = jobs
+	s.mu.Unlock()
+	if s.history != nil {
+		s.history.observe(*jobs)
+	}
+	return nil
+}
+
+// count Return the number of jobs of the snapshot
+func (s *jobsSource) count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.jobs == nil {
+		return 0
+	}
+	return len(*s.jobs)
+}
+
+func (s *jobsSource) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range prometheusMetrics {
+		ch <- desc
+	}
+	for _, desc := range stageMetrics {
+		ch <- desc
+	}
+	ch <- testSuiteCountDesc
+	ch <- testSuiteDurationDesc
+	ch <- testCaseDurationDesc
+	ch <- testCaseFailedDesc
+	ch <- jobInfoDesc
+	if s.history != nil {
+		s.history.Describe(ch)
+	}
+	s.filter.filtered.Describe(ch)
+	s.cache.Describe(ch)
+}
+
+func (s *jobsSource) Collect(ch chan<- prometheus.Metric) {
+	s.filter.filtered.Collect(ch)
+	s.cache.Collect(ch)
+	if s.history != nil {
+		s.history.Collect(ch)
+	}
+	s.mu.RLock()
+	jobs := s.jobs
+	s.mu.RUnlock()
+	if jobs == nil {
+		return
+	}
+	for _, job := range *jobs {
+		labels := jobLabelValues(&job)
+		for key, value := range prepareMetrics(&job) {
+			if isStateSetMetric(key) {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(prometheusMetrics[key], prometheus.GaugeValue, value, labels...)
+		}
+		if config.Global.MetricStyle == MetricStyleStateSet {
+			collectStateSets(ch, &job, labels)
+		}
+		collectJobInfo(ch, &job)
+		collectStages(ch, &job, labels)
+		collectTestReport(ch, &job, labels)
+	}
+}
@@ -0,0 +1,244 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Crawl strategies
+const (
+	// StrategyFolder One request per folder
+	StrategyFolder = "folder"
+	// StrategyTree One request per --crawl-tree-depth levels of folders
+	StrategyTree = "tree"
+)
+
+// CrawlStrategies Supported crawl strategies
+var CrawlStrategies = []string{StrategyFolder, StrategyTree}
+
+// MaxCrawlTreeDepth Maximum levels of folders of a tree query. Each level repeats the fields of the jobs,
+// about 1.3KB, and Jenkins refuses the urls above 8KB
+const MaxCrawlTreeDepth = 5
+
+// crawler Walk the Jenkins folders concurrently with a bounded number of workers
+type crawler struct {
+	api     *jenkinsAPI
+	filter  *jobFilter
+	cache   *folderCache
+	workers chan struct{} // Semaphore bounding the number of folders fetched at once
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	visited  map[string]struct{} // Visited/explored folders
+	jobs     []job               // Discovered jobs
+	err      error               // First error met, stops the crawl
+	bytes    int64               // Size of the folder replies downloaded
+	requests int                 // Number of folder requests sent
+	states   map[string]int      // Number of folders by state, see folderStates
+}
+
+func newCrawler(api *jenkinsAPI, filter *jobFilter, cache *folderCache, workers int) *crawler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &crawler{
+		api:     api,
+		filter:  filter,
+		cache:   cache,
+		workers: make(chan struct{}, workers),
+		visited: make(map[string]struct{}),
+		states:  make(map[string]int),
+	}
+}
+
+// crawl Walk Jenkins from the root url and return the jobs sorted by url
+func (c *crawler) crawl(ctx context.Context, url string) ([]job, error) {
+	start := time.Now()
+	ctx, c.cancel = context.WithCancel(ctx)
+	defer c.cancel()
+	c.visit(ctx, &jFolder{URL: url})
+	c.wg.Wait()
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.cache.done(c.visited, c.bytes, c.states, c.requests, time.Since(start))
+	// Workers finish in any order, sort to keep the output stable between crawls
+	sort.Slice(c.jobs, func(i, k int) bool {
+		return c.jobs[i].URL < c.jobs[k].URL
+	})
+	return c.jobs, nil
+}
+
+// visit Schedule the walk of a folder unless it was already visited
+func (c *crawler) visit(ctx context.Context, folder *jFolder) {
+	c.mu.Lock()
+	if _, ok := c.visited[folder.URL]; ok {
+		c.mu.Unlock()
+		return
+	}
+	c.visited[folder.URL] = struct{}{}
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		select {
+		case c.workers <- struct{}{}:
+		case <-ctx.Done():
+			c.fail(ctx.Err(), folder.URL)
+			return
+		}
+		folders, err := c.walkAndGetJobs(ctx, folder)
+		<-c.workers
+		if err != nil {
+			c.fail(err, folder.URL)
+			return
+		}
+		for _, f := range folders {
+			c.visit(ctx, f)
+		}
+	}()
+}
+
+// walkAndGetJobs Fetch a folder, keep its jobs and return its sub folders.
+// The folder of the previous crawls is reused when idle, or when Jenkins answers it didn't change
+func (c *crawler) walkAndGetJobs(ctx context.Context, folder *jFolder) ([]*jFolder, error) {
+	cached := c.cache.get(folder.URL)
+	if cached != nil && cached.fresh() {
+		logrus.Debug("Reusing idle folder ", folder.URL)
+		return c.keep(cached, folderReused, 0), nil
+	}
+	// Folders too large for a tree query are fetched alone from then on
+	depth := 1
+	if config.Global.CrawlStrategy == StrategyTree && (cached == nil || !cached.tooLarge) {
+		depth = config.Global.CrawlTreeDepth
+	}
+	// Remember the parent chain of the jobs and sub folders, the root of Jenkins is not a folder
+	parent := folder
+	if folder.Name == "" {
+		parent = nil
+	}
+	var jobsList []job
+	var subFolders []*jFolder
+	// The filtered jobs are counted once the reply is fully read, not twice when it is fetched again
+	filtered := make(map[string]int)
+	emit := func(j *job) {
+		c.walkJob(j, parent, &jobsList, &subFolders, filtered)
+	}
+	next, size, notModified, err := c.fetch(ctx, folder, depth, cached, emit)
+	if depth > 1 && errors.Is(err, errReplyTooLarge) {
+		logrus.Info("Tree reply of ", folder.URL, " above ", config.Global.CrawlTreeMaxBytes, " bytes, fetching its folders one by one")
+		depth = 1
+		jobsList, subFolders, filtered = nil, nil, make(map[string]int)
+		next, size, notModified, err = c.fetch(ctx, folder, depth, cached, emit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.filter.count(filtered)
+	if notModified && cached != nil {
+		logrus.Debug("Folder ", folder.URL, " not modified")
+		entry := *cached
+		entry.fetched = time.Now()
+		c.cache.put(folder.URL, &entry)
+		return c.keep(&entry, folderNotModified, 0), nil
+	}
+
+	if config.Global.PipelineStages {
+		if err := c.api.fetchStages(ctx, jobsList); err != nil {
+			return nil, err
+		}
+	}
+	if config.Global.TestReport {
+		if err := c.api.fetchTestReports(ctx, jobsList); err != nil {
+			return nil, err
+		}
+	}
+	entry := &folderEntry{
+		validators: next,
+		depth:      depth,
+		tooLarge:   depth < config.Global.CrawlTreeDepth && config.Global.CrawlStrategy == StrategyTree,
+		fetched:    time.Now(),
+		active:     isFolderActive(jobsList),
+		jobs:       jobsList,
+		folders:    subFolders,
+	}
+	c.cache.put(folder.URL, entry)
+	return c.keep(entry, folderFetched, size), nil
+}
+
+// fetch Request the jobs of the folder down to depth levels of folders, with the validators of the
+// cached reply when it was fetched at the same depth
+func (c *crawler) fetch(ctx context.Context, folder *jFolder, depth int, cached *folderEntry, emit func(*job)) (validators, int64, bool, error) {
+	var previous validators
+	if cached != nil && cached.depth == depth {
+		previous = cached.validators
+	}
+	limit := config.Global.MaxReplyBytes
+	if depth > 1 && (limit == 0 || config.Global.CrawlTreeMaxBytes < limit) {
+		limit = config.Global.CrawlTreeMaxBytes
+	}
+	logrus.Debug("Walking ", folder.URL, " down to ", depth, " levels")
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+	return c.api.requestJobsIfModified(ctx, folder.URL+"api/json"+createTreeQuery(depth), previous, limit, emit)
+}
+
+// walkJob Keep a job of a folder reply, or the jobs of a sub folder included in the reply.
+// The sub folders whose jobs were not included are added to subFolders, the jobs and folders left out
+// are counted in filtered by rule
+func (c *crawler) walkJob(j *job, parent *jFolder, jobsList *[]job, subFolders *[]*jFolder, filtered map[string]int) {
+	if !isFolder(j) {
+		if rule := c.filter.jobRule(j); rule != "" {
+			filtered[rule]++
+			return
+		}
+		j.Parent = parent
+		*jobsList = append(*jobsList, *j)
+		return
+	}
+	if rule := c.filter.folderRule(j); rule != "" {
+		filtered[rule]++
+		return
+	}
+	sub := &jFolder{Name: j.Name, FullName: j.FullName, URL: j.URL, Class: j.Class, Parent: parent}
+	// The jobs of the folders at the last level of the query are missing, not empty
+	if j.Jobs == nil {
+		*subFolders = append(*subFolders, sub)
+		return
+	}
+	for i := range j.Jobs {
+		c.walkJob(&j.Jobs[i], sub, jobsList, subFolders, filtered)
+	}
+}
+
+// keep Add the jobs of the folder to the crawl, and return its sub folders
+func (c *crawler) keep(entry *folderEntry, state string, size int64) []*jFolder {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jobs = append(c.jobs, entry.jobs...)
+	c.bytes += size
+	c.states[state]++
+	return entry.folders
+}
+
+// fail Record the first error and stop the other workers
+func (c *crawler) fail(err error, url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		if _, ok := err.(*JenkinsError); !ok {
+			err = transportError(url, err)
+		}
+		c.err = err
+		c.cancel()
+	}
+}
@@ -0,0 +1,148 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// nestedFolders Fake Jenkins with fanout sub folders per folder down to depth levels,
+// each folder holding two jobs and replying after latency
+func nestedFolders(fanout, depth int, latency time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		folder := strings.TrimSuffix(r.URL.Path, "api/json")
+		jobs := []fakeJob{{Name: "build"}, {Name: "deploy"}}
+		if strings.Count(folder, "job/") < depth {
+			for i := 0; i < fanout; i++ {
+				jobs = append(jobs, fakeJob{Name: fmt.Sprint("folder", i), Folder: true})
+			}
+		}
+		time.Sleep(latency)
+		writeJobs(w, r, folder, jobs)
+	})
+}
+
+func crawlOnce(t testing.TB, f *fakeJenkins, workers int) []job {
+	api := newJenkinsAPI(f.instance())
+	jobs, err := newCrawler(api, newJobFilter(), newFolderCache(), workers).crawl(context.Background(), api.getJenkinsApiUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jobs
+}
+
+func TestCrawlOrderStable(t *testing.T) {
+	setTestConfig(t, nil)
+	f := newFakeJenkins(t, nestedFolders(4, 3, 0))
+	want := crawlOnce(t, f, 1)
+	// 1 + 4 + 16 + 64 folders of 2 jobs
+	if len(want) != 170 {
+		t.Fatalf("got %d jobs, want 170", len(want))
+	}
+	urls := jobURLs(f, want)
+	if !sort.StringsAreSorted(urls) {
+		t.Errorf("jobs not sorted by url: %v", urls)
+	}
+	for i := 0; i < 5; i++ {
+		if got := jobURLs(f, crawlOnce(t, f, 16)); !reflect.DeepEqual(got, urls) {
+			t.Fatalf("crawl %d with 16 workers returned %v, want %v", i, got, urls)
+		}
+	}
+}
+
+func TestCrawlStopsCycles(t *testing.T) {
+	setTestConfig(t, nil)
+	f := newFakeJenkins(t, fakeTree{
+		"/": {{Name: "a", Folder: true}},
+		"/job/a/": {
+			{Name: "x"},
+			{Name: "b", Folder: true},
+			{Name: "root", Folder: true, Path: "/"},
+			{Name: "self", Folder: true, Path: "/job/a/"},
+		},
+		"/job/a/job/b/": {
+			{Name: "y"},
+			{Name: "parent", Folder: true, Path: "/job/a/"},
+		},
+	})
+	jobs := crawlOnce(t, f, 4)
+	if got, want := jobURLs(f, jobs), []string{"/job/a/job/b/job/y/", "/job/a/job/x/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+	for _, path := range []string{"/api/json", "/job/a/api/json", "/job/a/job/b/api/json"} {
+		if got := f.count(path); got != 1 {
+			t.Errorf("%s requested %d times, want once", path, got)
+		}
+	}
+}
+
+// BenchmarkCrawl Crawl 1111 nested folders answering in 1ms
+func BenchmarkCrawl(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprint("workers=", workers), func(b *testing.B) {
+			setTestConfig(b, func(c *config.Config) {
+				c.CrawlWorkers = workers
+			})
+			f := newFakeJenkins(b, nestedFolders(10, 3, time.Millisecond))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if jobs := crawlOnce(b, f, workers); len(jobs) != 2222 {
+					b.Fatalf("got %d jobs, want 2222", len(jobs))
+				}
+			}
+		})
+	}
+}
+
+func TestTreeFallbackCountsFilteredOnce(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.CrawlStrategy = StrategyTree
+		c.CrawlTreeDepth = 2
+		c.CrawlTreeMaxBytes = 1000
+		c.JobsExclude = []string{"old"}
+	})
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Count(r.URL.RawQuery, "jobs[") == 1 {
+			writeJobs(w, r, "/", []fakeJob{{Name: "old"}, {Name: "app"}})
+			return
+		}
+		// The tree reply is cut by the size limit after the excluded job
+		fmt.Fprintf(w, `{"jobs":[{"_class":"hudson.model.FreeStyleProject","name":"old","fullName":"old","color":"blue"},{"name":"app","padding":"%s"}]}`, strings.Repeat("x", 2000))
+	}))
+	api := newJenkinsAPI(f.instance())
+	filter := newJobFilter()
+	jobs, err := newCrawler(api, filter, newFolderCache(), 1).crawl(context.Background(), api.getJenkinsApiUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jobURLs(f, jobs), []string{"/job/app/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+	if got := f.count("/api/json"); got != 2 {
+		t.Errorf("got %d requests, want the tree one and the fallback", got)
+	}
+	if got := testutil.ToFloat64(filter.filtered.WithLabelValues(filterGlob)); got != 1 {
+		t.Errorf("got jenkins_jobs_filtered_total{rule=\"glob\"} %v, want 1", got)
+	}
+}
+
+func TestMaxCrawlTreeDepth(t *testing.T) {
+	for _, history := range []bool{false, true} {
+		setTestConfig(t, func(c *config.Config) {
+			c.BuildHistory = history
+			c.HistoryBuilds = 100
+		})
+		// Room is left for the folder url within the 8KB Jenkins accepts
+		if size := len(createTreeQuery(MaxCrawlTreeDepth)); size > 7500 {
+			t.Errorf("tree query of %d levels is %d bytes with history %v", MaxCrawlTreeDepth, size, history)
+		}
+	}
+}
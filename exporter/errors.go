@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrorKind Category of an error met while talking to Jenkins
+type ErrorKind string
+
+// Error kinds, also used as the "kind" label of jenkins_scrape_errors_total
+const (
+	ErrAuth     ErrorKind = "auth"
+	ErrNotFound ErrorKind = "not_found"
+	ErrTimeout  ErrorKind = "timeout"
+	ErrDecode   ErrorKind = "decode"
+	ErrServer   ErrorKind = "server"
+	// ErrUnavailable The request wasn't sent, the circuit breaker being open
+	ErrUnavailable ErrorKind = "unavailable"
+)
+
+var errorKinds = []ErrorKind{ErrAuth, ErrNotFound, ErrTimeout, ErrDecode, ErrServer, ErrUnavailable}
+
+// JenkinsError Error returned by the requests made to Jenkins
+type JenkinsError struct {
+	Kind       ErrorKind
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *JenkinsError) Error() string {
+	msg := fmt.Sprintf("jenkins %s error on %s", e.Kind, e.URL)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (HTTP %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *JenkinsError) Unwrap() error {
+	return e.Err
+}
+
+// errorKindOf Return the kind of err, ErrServer when it is not a JenkinsError
+func errorKindOf(err error) ErrorKind {
+	var jErr *JenkinsError
+	if errors.As(err, &jErr) {
+		return jErr.Kind
+	}
+	return ErrServer
+}
+
+// Build a JenkinsError from an HTTP status code
+func statusError(url string, statusCode int) *JenkinsError {
+	kind := ErrServer
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		kind = ErrAuth
+	case statusCode == http.StatusNotFound:
+		kind = ErrNotFound
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		kind = ErrTimeout
+	}
+	return &JenkinsError{Kind: kind, URL: url, StatusCode: statusCode}
+}
+
+// Build a JenkinsError from an error returned by the http client
+func transportError(url string, err error) *JenkinsError {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return &JenkinsError{Kind: ErrTimeout, URL: url, Err: err}
+	}
+	return &JenkinsError{Kind: ErrServer, URL: url, Err: err}
+}
@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func statusHandler(code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(code), code)
+	})
+}
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.Handler
+		kind    ErrorKind
+	}{
+		{"unauthorized", statusHandler(http.StatusUnauthorized), ErrAuth},
+		{"forbidden", statusHandler(http.StatusForbidden), ErrAuth},
+		{"not found", statusHandler(http.StatusNotFound), ErrNotFound},
+		{"internal error", statusHandler(http.StatusInternalServerError), ErrServer},
+		{"bad gateway", statusHandler(http.StatusBadGateway), ErrServer},
+		{"gateway timeout", statusHandler(http.StatusGatewayTimeout), ErrTimeout},
+		{"slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}), ErrTimeout},
+		{"bad json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"jobs": [`))
+		}), ErrDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *config.Config) {
+				c.JenkinsAPITimeout = 100 * time.Millisecond
+			})
+			f := newFakeJenkins(t, tt.handler)
+			var v struct{}
+			err := newJenkinsAPI(f.instance()).decodeJson(context.Background(), f.URL+"/api/json", &v)
+			jErr, ok := err.(*JenkinsError)
+			if !ok {
+				t.Fatalf("got error %v, want a *JenkinsError", err)
+			}
+			if jErr.Kind != tt.kind {
+				t.Errorf("got kind %q, want %q: %v", jErr.Kind, tt.kind, err)
+			}
+		})
+	}
+}
+
+func TestRefreshKeepsSnapshot(t *testing.T) {
+	setTestConfig(t, nil)
+	var failure int32 // Status code of the replies, 0 to serve the tree
+	tree := fakeTree{"/": {{Name: "a"}, {Name: "b"}}}
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := atomic.LoadInt32(&failure); code != 0 {
+			http.Error(w, http.StatusText(int(code)), int(code))
+			return
+		}
+		tree.ServeHTTP(w, r)
+	}))
+	c := NewJenkinsCollector(f.instance(), nil)
+	ctx := context.Background()
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(c.up); got != 1 {
+		t.Errorf("got jenkins_up %v, want 1", got)
+	}
+	lastSuccess := testutil.ToFloat64(c.lastSuccessfulScrape)
+
+	for _, tt := range []struct {
+		code int
+		kind ErrorKind
+	}{
+		{http.StatusInternalServerError, ErrServer},
+		{http.StatusUnauthorized, ErrAuth},
+		{http.StatusInternalServerError, ErrServer},
+	} {
+		atomic.StoreInt32(&failure, int32(tt.code))
+		before := testutil.ToFloat64(c.scrapeErrors.WithLabelValues(string(tt.kind)))
+		if err := c.Refresh(ctx); errorKindOf(err) != tt.kind {
+			t.Fatalf("got error %v, want a %q error", err, tt.kind)
+		}
+		if got := c.jobs.count(); got != 2 {
+			t.Errorf("got %d jobs after a %d, want the 2 of the last snapshot", got, tt.code)
+		}
+		if got := testutil.ToFloat64(c.up); got != 0 {
+			t.Errorf("got jenkins_up %v after a %d, want 0", got, tt.code)
+		}
+		if got := testutil.ToFloat64(c.scrapeErrors.WithLabelValues(string(tt.kind))); got != before+1 {
+			t.Errorf("got jenkins_scrape_errors_total{kind=%q} %v, want %v", tt.kind, got, before+1)
+		}
+		if got := testutil.ToFloat64(c.lastSuccessfulScrape); got != lastSuccess {
+			t.Errorf("last successful scrape moved to %v on a failed crawl", got)
+		}
+	}
+
+	atomic.StoreInt32(&failure, 0)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(c.up); got != 1 {
+		t.Errorf("got jenkins_up %v after recovering, want 1", got)
+	}
+}
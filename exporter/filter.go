@@ -0,0 +1,165 @@
+package exporter
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rules a job or a folder can be filtered by, also used as the "rule" label of jenkins_jobs_filtered_total
+const (
+	filterGlob     = "glob"
+	filterRegex    = "regex"
+	filterClass    = "class"
+	filterDepth    = "depth"
+	filterDisabled = "disabled"
+)
+
+var filterRules = []string{filterGlob, filterRegex, filterClass, filterDepth, filterDisabled}
+
+// jobFilter Include and exclude rules evaluated during the crawl, see crawler.walkJob.
+// Excluded folders are not walked, so everything below them is excluded too
+type jobFilter struct {
+	include        []string
+	exclude        []string
+	includeRegex   []*regexp.Regexp
+	excludeRegex   []*regexp.Regexp
+	includeClasses map[string]bool
+	excludeClasses map[string]bool
+	maxDepth       int
+	skipDisabled   bool
+
+	filtered *prometheus.CounterVec
+}
+
+// newJobFilter Create the filter from the configuration, the regular expressions are checked when it is loaded
+func newJobFilter() *jobFilter {
+	f := &jobFilter{
+		include:        config.Global.JobsInclude,
+		exclude:        config.Global.JobsExclude,
+		includeRegex:   compileAnchored(config.Global.JobsIncludeRegex),
+		excludeRegex:   compileAnchored(config.Global.JobsExcludeRegex),
+		includeClasses: toSet(config.Global.JobsIncludeClasses),
+		excludeClasses: toSet(config.Global.JobsExcludeClasses),
+		maxDepth:       config.Global.JobsMaxDepth,
+		skipDisabled:   config.Global.JobsSkipDisabled,
+		filtered: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "jenkins_jobs_filtered_total",
+				Help: "Number of jobs and folders left out of the crawls by filtering rule",
+			},
+			[]string{
+				"rule",
+			},
+		),
+	}
+	for _, rule := range filterRules {
+		f.filtered.With(prometheus.Labels{"rule": rule})
+	}
+	return f
+}
+
+// folderRule Return the rule leaving the folder out, empty if it must be fetched
+func (f *jobFilter) folderRule(j *job) string {
+	rule := f.excludedBy(j)
+	// Jobs of a folder at the max depth would be too deep
+	if rule == "" && f.maxDepth > 0 && jobDepth(j) >= f.maxDepth {
+		rule = filterDepth
+	}
+	return rule
+}
+
+// jobRule Return the rule leaving the job out, empty if it must be exported
+func (f *jobFilter) jobRule(j *job) string {
+	rule := f.excludedBy(j)
+	if rule == "" {
+		rule = f.notIncludedBy(j)
+	}
+	if rule == "" && f.maxDepth > 0 && jobDepth(j) > f.maxDepth {
+		rule = filterDepth
+	}
+	if rule == "" && f.skipDisabled && isJobDisabled(j) {
+		rule = filterDisabled
+	}
+	return rule
+}
+
+// count Add the jobs and folders left out by rule to jenkins_jobs_filtered_total
+func (f *jobFilter) count(filtered map[string]int) {
+	for rule, n := range filtered {
+		f.filtered.With(prometheus.Labels{"rule": rule}).Add(float64(n))
+	}
+}
+
+// Return the first exclude rule matching the job, empty if none
+func (f *jobFilter) excludedBy(j *job) string {
+	switch {
+	case matchGlobs(f.exclude, j.FullName):
+		return filterGlob
+	case matchRegexps(f.excludeRegex, j.FullName):
+		return filterRegex
+	case f.excludeClasses[j.Class]:
+		return filterClass
+	}
+	return ""
+}
+
+// Return the first include rule the job doesn't match, empty if it matches all of them
+func (f *jobFilter) notIncludedBy(j *job) string {
+	switch {
+	case len(f.include) > 0 && !matchGlobs(f.include, j.FullName):
+		return filterGlob
+	case len(f.includeRegex) > 0 && !matchRegexps(f.includeRegex, j.FullName):
+		return filterRegex
+	case len(f.includeClasses) > 0 && !f.includeClasses[j.Class]:
+		return filterClass
+	}
+	return ""
+}
+
+// Return the number of folders above the job plus one, ex: team/project/main -> 3
+func jobDepth(j *job) int {
+	return strings.Count(j.FullName, "/") + 1
+}
+
+func isJobDisabled(j *job) bool {
+	return j.Disabled || j.ColorPtr != nil && strings.HasPrefix(*j.ColorPtr, "disabled")
+}
+
+func matchGlobs(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegexps(regexps []*regexp.Regexp, name string) bool {
+	for _, r := range regexps {
+		if r.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile the regular expressions, anchored to match the whole name
+func compileAnchored(exprs []string) []*regexp.Regexp {
+	regexps := make([]*regexp.Regexp, 0, len(exprs))
+	for _, e := range exprs {
+		regexps = append(regexps, regexp.MustCompile("^(?:"+e+")$"))
+	}
+	return regexps
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,168 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestJobFilter(t *testing.T) {
+	disabledColor := "disabled"
+	tests := []struct {
+		name   string
+		set    func(c *config.Config)
+		folder bool
+		job    job
+		rule   string // Rule filtering the job out, empty when kept
+	}{
+		{"no rules", nil, false, job{FullName: "team/app"}, ""},
+		{"exclude glob", func(c *config.Config) { c.JobsExclude = []string{"team/*"} }, false, job{FullName: "team/app"}, filterGlob},
+		{"exclude glob folder", func(c *config.Config) { c.JobsExclude = []string{"team/*"} }, true, job{FullName: "team"}, ""},
+		{"exclude glob sub folder", func(c *config.Config) { c.JobsExclude = []string{"team/*"} }, true, job{FullName: "team/sub"}, filterGlob},
+		{"include glob", func(c *config.Config) { c.JobsInclude = []string{"team/*"} }, false, job{FullName: "team/app"}, ""},
+		{"not included glob", func(c *config.Config) { c.JobsInclude = []string{"team/*"} }, false, job{FullName: "other/app"}, filterGlob},
+		{"include glob folder", func(c *config.Config) { c.JobsInclude = []string{"team/*"} }, true, job{FullName: "other"}, ""},
+		{"exclude regex anchored", func(c *config.Config) { c.JobsExcludeRegex = []string{"app"} }, false, job{FullName: "team/app"}, ""},
+		{"exclude regex", func(c *config.Config) { c.JobsExcludeRegex = []string{".*/app"} }, false, job{FullName: "team/app"}, filterRegex},
+		{"include regex", func(c *config.Config) { c.JobsIncludeRegex = []string{"team/.*"} }, false, job{FullName: "team/app"}, ""},
+		{"not included regex", func(c *config.Config) { c.JobsIncludeRegex = []string{"team"} }, false, job{FullName: "teams"}, filterRegex},
+		{"exclude class", func(c *config.Config) { c.JobsExcludeClasses = []string{pipelineJobClass} }, false, job{FullName: "app", Class: pipelineJobClass}, filterClass},
+		{"exclude class folder", func(c *config.Config) { c.JobsExcludeClasses = []string{jenkinsFolderClasses[0]} }, true, job{FullName: "team", Class: jenkinsFolderClasses[0]}, filterClass},
+		{"include class", func(c *config.Config) { c.JobsIncludeClasses = []string{pipelineJobClass} }, false, job{FullName: "app", Class: pipelineJobClass}, ""},
+		{"not included class", func(c *config.Config) { c.JobsIncludeClasses = []string{pipelineJobClass} }, false, job{FullName: "app", Class: "hudson.model.FreeStyleProject"}, filterClass},
+		{"max depth", func(c *config.Config) { c.JobsMaxDepth = 2 }, false, job{FullName: "a/b"}, ""},
+		{"below max depth", func(c *config.Config) { c.JobsMaxDepth = 2 }, false, job{FullName: "a/b/c"}, filterDepth},
+		{"max depth folder", func(c *config.Config) { c.JobsMaxDepth = 2 }, true, job{FullName: "a"}, ""},
+		{"folder at max depth", func(c *config.Config) { c.JobsMaxDepth = 2 }, true, job{FullName: "a/b"}, filterDepth},
+		{"disabled kept", nil, false, job{FullName: "app", Disabled: true}, ""},
+		{"skip disabled", func(c *config.Config) { c.JobsSkipDisabled = true }, false, job{FullName: "app", Disabled: true}, filterDisabled},
+		{"skip disabled color", func(c *config.Config) { c.JobsSkipDisabled = true }, false, job{FullName: "app", ColorPtr: &disabledColor}, filterDisabled},
+		{"exclude before disabled", func(c *config.Config) {
+			c.JobsSkipDisabled = true
+			c.JobsExclude = []string{"app"}
+		}, false, job{FullName: "app", Disabled: true}, filterGlob},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, tt.set)
+			f := newJobFilter()
+			rule := f.jobRule
+			if tt.folder {
+				rule = f.folderRule
+			}
+			if got := rule(&tt.job); got != tt.rule {
+				t.Errorf("got rule %q, want %q", got, tt.rule)
+			}
+		})
+	}
+}
+
+func TestFilteredFolderNotFetched(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.JobsExclude = []string{"secret"}
+		c.JobsExcludeRegex = []string{"team/tmp-.*"}
+		c.JobsMaxDepth = 2
+	})
+	f := newFakeJenkins(t, fakeTree{
+		"/": {
+			{Name: "app"},
+			{Name: "secret", Folder: true},
+			{Name: "team", Folder: true},
+		},
+		"/job/secret/": {{Name: "hidden"}},
+		"/job/team/": {
+			{Name: "build"},
+			{Name: "tmp-1"},
+			{Name: "tmp-2"},
+			{Name: "sub", Folder: true},
+		},
+		"/job/team/job/sub/": {{Name: "deep"}},
+	})
+	api := newJenkinsAPI(f.instance())
+	filter := newJobFilter()
+	jobs, err := newCrawler(api, filter, newFolderCache(), 4).crawl(context.Background(), api.getJenkinsApiUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jobURLs(f, jobs), []string{"/job/app/", "/job/team/job/build/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+	for _, path := range []string{"/job/secret/api/json", "/job/team/job/sub/api/json"} {
+		if got := f.count(path); got != 0 {
+			t.Errorf("excluded folder %s requested %d times", path, got)
+		}
+	}
+	for rule, want := range map[string]float64{filterGlob: 1, filterRegex: 2, filterDepth: 1} {
+		if got := testutil.ToFloat64(filter.filtered.WithLabelValues(rule)); got != want {
+			t.Errorf("got jenkins_jobs_filtered_total{rule=%q} %v, want %v", rule, got, want)
+		}
+	}
+}
+
+func TestFilteredFolderTreeStrategy(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.CrawlStrategy = StrategyTree
+		c.CrawlTreeDepth = 2
+		c.JobsExclude = []string{"old", "team/secret"}
+	})
+	var f *fakeJenkins
+	item := func(name, fullName string, jobs []map[string]interface{}) map[string]interface{} {
+		path := "/job/" + strings.Replace(fullName, "/", "/job/", -1) + "/"
+		i := map[string]interface{}{"name": name, "fullName": fullName, "url": f.URL + path}
+		if jobs == nil {
+			i["_class"], i["color"] = "hudson.model.FreeStyleProject", "blue"
+		} else {
+			i["_class"] = jenkinsFolderClasses[0]
+			if len(jobs) > 0 {
+				i["jobs"] = jobs
+			}
+		}
+		return i
+	}
+	folder := []map[string]interface{}{}
+	f = newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var jobs []map[string]interface{}
+		switch r.URL.Path {
+		case "/api/json":
+			// The jobs of the folders of the first level are part of the reply, not the ones below
+			jobs = []map[string]interface{}{
+				item("app", "app", nil),
+				item("old", "old", []map[string]interface{}{item("x", "old/x", nil)}),
+				item("team", "team", []map[string]interface{}{
+					item("build", "team/build", nil),
+					item("secret", "team/secret", folder),
+					item("sub", "team/sub", folder),
+				}),
+			}
+		case "/job/team/job/sub/api/json":
+			jobs = []map[string]interface{}{item("deep", "team/sub/deep", nil)}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jobs": jobs})
+	}))
+	api := newJenkinsAPI(f.instance())
+	filter := newJobFilter()
+	jobs, err := newCrawler(api, filter, newFolderCache(), 4).crawl(context.Background(), api.getJenkinsApiUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jobURLs(f, jobs), []string{"/job/app/", "/job/team/job/build/", "/job/team/job/sub/job/deep/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+	// The excluded folder below the last level of the reply isn't fetched
+	if got := f.count("/job/team/job/secret/api/json"); got != 0 {
+		t.Errorf("excluded folder requested %d times", got)
+	}
+	// The folders are counted, not the jobs below them
+	if got := testutil.ToFloat64(filter.filtered.WithLabelValues(filterGlob)); got != 2 {
+		t.Errorf("got jenkins_jobs_filtered_total{rule=\"glob\"} %v, want 2", got)
+	}
+}
@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/sirupsen/logrus"
+)
+
+// crawlStatus Outcome of the crawls of a Jenkins instance, updated by Refresh
+type crawlStatus struct {
+	mu          sync.Mutex
+	lastCrawl   time.Time
+	lastSuccess time.Time
+	duration    time.Duration
+	lastError   error
+}
+
+// instanceStatus Status of an instance in the /-/ready reply
+type instanceStatus struct {
+	Name        string     `json:"name"`
+	Ready       bool       `json:"ready"`
+	LastCrawl   *time.Time `json:"last_crawl,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Duration    float64    `json:"duration_seconds"`
+	Jobs        int        `json:"jobs"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+func (s *crawlStatus) record(start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCrawl = start
+	s.duration = time.Since(start)
+	s.lastError = err
+	if err == nil {
+		s.lastSuccess = start
+	}
+}
+
+// status Return the status of the collector. In the cached mode, the instance is ready once crawled
+// successfully, until its last successful crawl is older than config.Global.ReadyPeriods update periods,
+// a period being the update rate, raised to the minimum interval, plus the duration of the last crawl
+func (c *JenkinsCollector) status() instanceStatus {
+	c.crawl.mu.Lock()
+	defer c.crawl.mu.Unlock()
+	status := instanceStatus{
+		Name:     c.instance.Name,
+		Ready:    true,
+		Duration: c.crawl.duration.Seconds(),
+		Jobs:     c.jobs.count(),
+	}
+	if !c.crawl.lastCrawl.IsZero() {
+		lastCrawl := c.crawl.lastCrawl
+		status.LastCrawl = &lastCrawl
+	}
+	if !c.crawl.lastSuccess.IsZero() {
+		lastSuccess := c.crawl.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if c.crawl.lastError != nil {
+		status.LastError = c.crawl.lastError.Error()
+	}
+	// In the scrape mode, Jenkins is only crawled by the scrapes that readiness would keep away
+	if config.Global.CollectionMode == ModeCached {
+		maxAge := time.Duration(config.Global.ReadyPeriods) * (c.updateRate() + c.crawl.duration)
+		status.Ready = status.LastSuccess != nil && time.Since(c.crawl.lastSuccess) <= maxAge
+	}
+	return status
+}
+
+// Healthy Replies with 200 while the process is alive
+func Healthy(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte("Healthy"))
+}
+
+// readyHandler Reply with 200 when at least one instance is ready, else 503, along with the status of the instances.
+// An instance down must not take the others out of the scrapes
+func readyHandler(jenkinsCollectors []*JenkinsCollector) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		reply := struct {
+			Ready     bool             `json:"ready"`
+			Instances []instanceStatus `json:"instances"`
+		}{}
+		for _, c := range jenkinsCollectors {
+			status := c.status()
+			reply.Ready = reply.Ready || status.Ready
+			reply.Instances = append(reply.Instances, status)
+		}
+		body, err := json.Marshal(reply)
+		if err != nil {
+			logrus.Error("Error encoding the readiness reply: ", err)
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		if reply.Ready {
+			rw.WriteHeader(http.StatusOK)
+		} else {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		rw.Write(body)
+	})
+}
@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+)
+
+func TestReadyHandler(t *testing.T) {
+	setTestConfig(t, nil)
+	up := newFakeJenkins(t, fakeTree{"/": {{Name: "a"}}})
+	down := newFakeJenkins(t, statusHandler(http.StatusServiceUnavailable))
+	collector := func(f *fakeJenkins, name string) *JenkinsCollector {
+		instance := f.instance()
+		instance.Name = name
+		c := NewJenkinsCollector(instance, nil)
+		c.Refresh(context.Background())
+		return c
+	}
+	ready := func(collectors ...*JenkinsCollector) (int, map[string]bool) {
+		rec := httptest.NewRecorder()
+		readyHandler(collectors).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+		var reply struct {
+			Ready     bool             `json:"ready"`
+			Instances []instanceStatus `json:"instances"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Ready != (rec.Code == http.StatusOK) {
+			t.Errorf("got ready %v with status %d", reply.Ready, rec.Code)
+		}
+		instances := make(map[string]bool)
+		for _, s := range reply.Instances {
+			instances[s.Name] = s.Ready
+		}
+		return rec.Code, instances
+	}
+
+	ok, ko := collector(up, "ok"), collector(down, "ko")
+	code, instances := ready(ok, ko)
+	if code != http.StatusOK {
+		t.Errorf("got status %d with one instance ready, want 200", code)
+	}
+	if !instances["ok"] || instances["ko"] || len(instances) != 2 {
+		t.Errorf("got instances %v, want ok ready and ko not ready", instances)
+	}
+	if code, _ := ready(ko, collector(down, "ko2")); code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d without instance ready, want 503", code)
+	}
+}
+
+func TestReadyMinInterval(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.ReadyPeriods = 1
+		c.MinRefreshInterval = time.Minute
+	})
+	f := newFakeJenkins(t, fakeTree{"/": {{Name: "a"}}})
+	instance := f.instance()
+	instance.UpdateRate = time.Millisecond
+	c := NewJenkinsCollector(instance, nil)
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Crawled every minute, not every millisecond
+	time.Sleep(50 * time.Millisecond)
+	if !c.status().Ready {
+		t.Error("got not ready within the minimum interval of the last crawl")
+	}
+
+	config.Global.MinRefreshInterval = 0
+	if c.status().Ready {
+		t.Error("got ready after a period of the update rate without crawl")
+	}
+}
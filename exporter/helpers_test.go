@@ -0,0 +1,170 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// setTestConfig Set config.Global to the defaults of the flags, changed by set, and restore it at the end of the test
+func setTestConfig(t testing.TB, set func(c *config.Config)) {
+	saved := config.Global
+	config.Global = config.Config{
+		JenkinsAPITimeout:  5 * time.Second,
+		TLSMinVersion:      "1.2",
+		RetryBackoff:       time.Millisecond,
+		RetryMaxBackoff:    10 * time.Millisecond,
+		BreakerCooldown:    30 * time.Second,
+		LimitBurst:         10,
+		CrawlWorkers:       4,
+		CrawlStrategy:      StrategyFolder,
+		CrawlTreeDepth:     3,
+		CrawlTreeMaxBytes:  32 << 20,
+		MaxStages:          20,
+		TestReportTop:      10,
+		MetricStyle:        MetricStyleLegacy,
+		HistoryBuilds:      20,
+		ShutdownTimeout:    time.Second,
+		ReadyPeriods:       3,
+		MetricsPath:        "/metrics",
+		MetricsUpdateRate:  time.Second,
+		CollectionMode:     ModeCached,
+		ExporterHostPort:   "127.0.0.1:0",
+		MinRefreshInterval: 0,
+	}
+	if set != nil {
+		set(&config.Global)
+	}
+	t.Cleanup(func() {
+		config.Global = saved
+		// The ports of the closed test servers get reused
+		limiters.Lock()
+		limiters.byURL = make(map[string]*limiter)
+		limiters.Unlock()
+		breakers.Lock()
+		breakers.byURL = make(map[string]*breaker)
+		breakers.Unlock()
+		transports.Lock()
+		transports.byKey = make(map[transportKey]http.RoundTripper)
+		transports.Unlock()
+	})
+}
+
+// fakeJenkins Test server counting the requests by path
+type fakeJenkins struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newFakeJenkins(t testing.TB, handler http.Handler) *fakeJenkins {
+	f := &fakeJenkins{requests: make(map[string]int)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests[r.URL.Path]++
+		f.mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// count Return the number of requests to path
+func (f *fakeJenkins) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+// instance Return an instance targeting the server
+func (f *fakeJenkins) instance() *config.Instance {
+	return &config.Instance{
+		Name:       "test",
+		HostPort:   strings.TrimPrefix(f.URL, "http://"),
+		Timeout:    config.Global.JenkinsAPITimeout,
+		UpdateRate: config.Global.MetricsUpdateRate,
+	}
+}
+
+// fakeJob Job or folder listed by a fakeTree
+type fakeJob struct {
+	Name     string
+	Class    string // The class of a freestyle job or a folder by default
+	Folder   bool
+	Disabled bool
+	Path     string // Path of the job, below its folder by default
+}
+
+// fakeTree Folders of a fake Jenkins by path, "/" being the root
+type fakeTree map[string][]fakeJob
+
+// ServeHTTP Reply to the api/json requests of the folders, ignoring the tree query
+func (tree fakeTree) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	folder := strings.TrimSuffix(r.URL.Path, "api/json")
+	jobs, ok := tree[folder]
+	if !ok || folder == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	writeJobs(w, r, folder, jobs)
+}
+
+func writeJobs(w http.ResponseWriter, r *http.Request, folder string, jobs []fakeJob) {
+	prefix := strings.Trim(strings.Replace(strings.TrimPrefix(folder, "/"), "job/", "", -1), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	reply := struct {
+		Jobs []map[string]interface{} `json:"jobs"`
+	}{Jobs: []map[string]interface{}{}}
+	for _, j := range jobs {
+		path := j.Path
+		if path == "" {
+			path = folder + "job/" + j.Name + "/"
+		}
+		class := j.Class
+		if class == "" {
+			class = "hudson.model.FreeStyleProject"
+			if j.Folder {
+				class = jenkinsFolderClasses[0]
+			}
+		}
+		item := map[string]interface{}{
+			"_class":   class,
+			"name":     j.Name,
+			"fullName": prefix + j.Name,
+			"url":      "http://" + r.Host + path,
+			"disabled": j.Disabled,
+		}
+		if !j.Folder {
+			item["color"] = "blue"
+		}
+		reply.Jobs = append(reply.Jobs, item)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// jobURLs Return the urls of the jobs, without the server part
+func jobURLs(f *fakeJenkins, jobs []job) []string {
+	urls := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		urls = append(urls, strings.TrimPrefix(j.URL, f.URL))
+	}
+	return urls
+}
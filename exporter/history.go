@@ -0,0 +1,248 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Builds already observed of a job. Builds complete in any order, so the ones above
+// the last contiguous observed number are kept until the builds below them complete
+type jobHistory struct {
+	Last     int   `json:"last"`
+	Observed []int `json:"observed,omitempty"`
+}
+
+// HistoryStore Builds observed by the collectors of every instance, saved to a file
+// to not observe them again after a restart
+type HistoryStore struct {
+	path      string
+	mu        sync.Mutex
+	instances map[string]map[string]*jobHistory // Job histories by instance, then by job url
+}
+
+// LoadHistoryStore Read the store from path, an empty path keeps it in memory only
+func LoadHistoryStore(path string) (*HistoryStore, error) {
+	store := &HistoryStore{path: path, instances: make(map[string]map[string]*jobHistory)}
+	if path == "" {
+		return store, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.instances); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// save Write the store to a temporary file renamed over the previous one, to never leave it half written
+func (h *HistoryStore) save() {
+	if h.path == "" {
+		return
+	}
+	data, err := json.Marshal(h.instances)
+	if err != nil {
+		logrus.Error("Error encoding the build history: ", err)
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(h.path), filepath.Base(h.path)+".*")
+	if err != nil {
+		logrus.Error("Error saving the build history: ", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		logrus.Error("Error saving the build history: ", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		logrus.Error("Error saving the build history: ", err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), h.path); err != nil {
+		logrus.Error("Error saving the build history: ", err)
+	}
+}
+
+// buildHistory Counters and histograms of the builds completed since they were last observed
+type buildHistory struct {
+	store      *HistoryStore
+	instance   string
+	countFirst bool // Count the builds of a job completed before it was first seen
+
+	builds          *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	queuingDuration *prometheus.HistogramVec
+	totalDuration   *prometheus.HistogramVec
+}
+
+func newBuildHistory(store *HistoryStore, instance string) *buildHistory {
+	newHistogram := func(name, help string, buckets []float64) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "jenkins_job_build_" + name,
+				Help:    help,
+				Buckets: buckets,
+			},
+			jobLabels("result"),
+		)
+	}
+	return &buildHistory{
+		store:      store,
+		instance:   instance,
+		countFirst: config.Global.HistoryCountFirst,
+		builds: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "jenkins_builds_total",
+				Help: "Number of completed Jenkins builds by result and cause",
+			},
+			jobLabels("result", "cause"),
+		),
+		duration:        newHistogram("duration_seconds", "Jenkins build duration in seconds of the completed builds", config.Global.HistoryDurationBuckets),
+		queuingDuration: newHistogram("queuing_duration_seconds", "Jenkins build queuing duration in seconds of the completed builds", config.Global.HistoryQueueBuckets),
+		totalDuration:   newHistogram("total_duration_seconds", "Jenkins build total duration in seconds of the completed builds", config.Global.HistoryDurationBuckets),
+	}
+}
+
+func (h *buildHistory) Describe(ch chan<- *prometheus.Desc) {
+	h.builds.Describe(ch)
+	h.duration.Describe(ch)
+	h.queuingDuration.Describe(ch)
+	h.totalDuration.Describe(ch)
+}
+
+func (h *buildHistory) Collect(ch chan<- prometheus.Metric) {
+	h.builds.Collect(ch)
+	h.duration.Collect(ch)
+	h.queuingDuration.Collect(ch)
+	h.totalDuration.Collect(ch)
+}
+
+// observe Add the builds completed since the previous crawl to the histograms.
+// jobs is a whole crawl, the histories of the jobs not in it are dropped. The builds of a job
+// seen for the first time are only recorded as observed, unless countFirst is set
+func (h *buildHistory) observe(jobs []job) {
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	histories, ok := h.store.instances[h.instance]
+	if !ok {
+		histories = make(map[string]*jobHistory)
+		h.store.instances[h.instance] = histories
+	}
+	changed := false
+	crawled := make(map[string]bool, len(jobs))
+	for i := range jobs {
+		j := &jobs[i]
+		crawled[j.URL] = true
+		history, ok := histories[j.URL]
+		if !ok {
+			history = &jobHistory{}
+			histories[j.URL] = history
+		}
+		if h.observeJob(j, history, !ok && !h.countFirst) {
+			changed = true
+		}
+	}
+	for url := range histories {
+		if !crawled[url] {
+			delete(histories, url)
+			changed = true
+		}
+	}
+	if changed {
+		h.store.save()
+	}
+}
+
+// Observe the new completed builds of the job, and return whether its history changed.
+// With baseline, the builds are recorded as observed without being counted
+func (h *buildHistory) observeJob(j *job, history *jobHistory, baseline bool) bool {
+	// Builds are listed from the newest one
+	builds := make([]jStatus, len(j.Builds))
+	copy(builds, j.Builds)
+	sort.Slice(builds, func(a, b int) bool {
+		return builds[a].Number < builds[b].Number
+	})
+	// The numbers went back, the job was deleted and created again or its numbering was reset
+	if len(builds) > 0 && builds[len(builds)-1].Number < history.Last {
+		logrus.Info("Build numbers of ", j.URL, " went back, restarting its history")
+		*history = jobHistory{}
+	}
+	observed := make(map[int]bool, len(history.Observed))
+	for _, n := range history.Observed {
+		observed[n] = true
+	}
+
+	labels := jobLabelValues(j)
+	changed := false
+	running := false
+	for _, b := range builds {
+		if b.Number <= history.Last {
+			continue
+		}
+		if observed[b.Number] {
+			if !running {
+				history.Last = b.Number
+				changed = true
+			}
+			continue
+		}
+		if b.Building || b.Result == "" {
+			running = true
+			continue
+		}
+		if !baseline {
+			h.observeBuild(&b, labels)
+		}
+		changed = true
+		if running {
+			observed[b.Number] = true
+		} else {
+			history.Last = b.Number
+		}
+	}
+	if !changed {
+		return false
+	}
+
+	// Forget the builds now below the last contiguous one
+	history.Observed = history.Observed[:0]
+	for n := range observed {
+		if n > history.Last {
+			history.Observed = append(history.Observed, n)
+		}
+	}
+	sort.Ints(history.Observed)
+	return true
+}
+
+func (h *buildHistory) observeBuild(b *jStatus, jobLabels []string) {
+	result := whichResultState(*b)
+	cause := whichCauseState(*b)
+	if cause == "" {
+		cause = "none"
+	}
+	h.builds.WithLabelValues(appendLabels(jobLabels, result, cause)...).Inc()
+
+	labels := appendLabels(jobLabels, result)
+	h.duration.WithLabelValues(labels...).Observe(i2F64(b.Duration) / 1000)
+	if queuing := getTimeInQueueValue(b.Actions, "QueuingDuration"); queuing >= 0 {
+		h.queuingDuration.WithLabelValues(labels...).Observe(queuing)
+	}
+	if total := getTimeInQueueValue(b.Actions, "TotalDuration"); total >= 0 {
+		h.totalDuration.WithLabelValues(labels...).Observe(total)
+	}
+}
@@ -0,0 +1,200 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func completed(number int) jStatus {
+	return jStatus{Number: number, Result: "SUCCESS", Timestamp: 1700000000000, Duration: 60000}
+}
+
+func running(number int) jStatus {
+	return jStatus{Number: number, Building: true, Timestamp: 1700000000000}
+}
+
+// historyJob Return the job app listing the builds from the newest one, like Jenkins
+func historyJob(builds ...jStatus) job {
+	j := job{FullName: "app", URL: "http://jenkins/job/app/"}
+	for i := len(builds) - 1; i >= 0; i-- {
+		j.Builds = append(j.Builds, builds[i])
+	}
+	return j
+}
+
+// counted Return the number of builds counted by jenkins_builds_total
+func counted(t *testing.T, h *buildHistory) int {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(h)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, family := range families {
+		if family.GetName() != "jenkins_builds_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			total += int(m.GetCounter().GetValue())
+		}
+	}
+	return total
+}
+
+// jobHistoryOf Return the history of the job app
+func jobHistoryOf(h *buildHistory) jobHistory {
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	if history := h.store.instances[h.instance]["http://jenkins/job/app/"]; history != nil {
+		return *history
+	}
+	return jobHistory{}
+}
+
+func newTestHistory(t *testing.T, path string) *buildHistory {
+	store, err := LoadHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newBuildHistory(store, "test")
+}
+
+func TestHistoryFirstSight(t *testing.T) {
+	setTestConfig(t, nil)
+	h := newTestHistory(t, "")
+	h.observe([]job{historyJob(completed(1), completed(2), completed(3))})
+	if got := counted(t, h); got != 0 {
+		t.Errorf("got %d builds counted on first sight, want none", got)
+	}
+	if got := jobHistoryOf(h); got.Last != 3 {
+		t.Errorf("got last build %d, want 3", got.Last)
+	}
+	h.observe([]job{historyJob(completed(2), completed(3), completed(4))})
+	if got := counted(t, h); got != 1 {
+		t.Errorf("got %d builds counted, want the new one", got)
+	}
+
+	// Job without build yet, its first build is counted
+	h = newTestHistory(t, "")
+	h.observe([]job{historyJob()})
+	h.observe([]job{historyJob(completed(1))})
+	if got := counted(t, h); got != 1 {
+		t.Errorf("got %d builds counted, want the first build", got)
+	}
+
+	// Job gone from the crawl, seen again
+	h.observe(nil)
+	h.observe([]job{historyJob(completed(1), completed(2))})
+	if got := counted(t, h); got != 1 {
+		t.Errorf("got %d builds counted, want no more for the job seen again", got)
+	}
+}
+
+func TestHistoryCountFirst(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.HistoryCountFirst = true
+	})
+	h := newTestHistory(t, "")
+	h.observe([]job{historyJob(completed(1), completed(2), running(3))})
+	if got := counted(t, h); got != 2 {
+		t.Errorf("got %d builds counted on first sight, want the 2 completed ones", got)
+	}
+	h.observe([]job{historyJob(completed(1), completed(2), completed(3))})
+	if got := counted(t, h); got != 3 {
+		t.Errorf("got %d builds counted, want each build once", got)
+	}
+}
+
+func TestHistoryOrder(t *testing.T) {
+	setTestConfig(t, nil)
+	h := newTestHistory(t, "")
+	h.observe([]job{historyJob(completed(1), completed(2))})
+	// Listed from the newest, counted from the oldest
+	j := historyJob(completed(2), completed(3), completed(4), completed(5))
+	h.observe([]job{j})
+	if got := counted(t, h); got != 3 {
+		t.Errorf("got %d builds counted, want 3", got)
+	}
+	if got := jobHistoryOf(h); got.Last != 5 || len(got.Observed) != 0 {
+		t.Errorf("got history %+v, want last build 5", got)
+	}
+	h.observe([]job{j})
+	if got := counted(t, h); got != 3 {
+		t.Errorf("got %d builds counted, want no more on the same builds", got)
+	}
+}
+
+func TestHistoryOutOfOrder(t *testing.T) {
+	setTestConfig(t, nil)
+	h := newTestHistory(t, "")
+	// The running build completes after the first sight
+	h.observe([]job{historyJob(completed(1), running(2), completed(3))})
+	if got := jobHistoryOf(h); got.Last != 1 || !reflect.DeepEqual(got.Observed, []int{3}) {
+		t.Errorf("got history %+v, want last build 1 and 3 observed", got)
+	}
+	h.observe([]job{historyJob(completed(1), completed(2), completed(3), running(4), completed(5))})
+	if got := counted(t, h); got != 2 {
+		t.Errorf("got %d builds counted, want 2 and 5", got)
+	}
+	if got := jobHistoryOf(h); got.Last != 3 || !reflect.DeepEqual(got.Observed, []int{5}) {
+		t.Errorf("got history %+v, want last build 3 and 5 observed", got)
+	}
+	h.observe([]job{historyJob(completed(3), completed(4), completed(5))})
+	if got := counted(t, h); got != 3 {
+		t.Errorf("got %d builds counted, want 4 more", got)
+	}
+	if got := jobHistoryOf(h); got.Last != 5 || len(got.Observed) != 0 {
+		t.Errorf("got history %+v, want last build 5", got)
+	}
+}
+
+func TestHistoryReset(t *testing.T) {
+	setTestConfig(t, nil)
+	h := newTestHistory(t, "")
+	h.observe([]job{historyJob(completed(4), completed(5))})
+	// Job created again, its builds are all new
+	h.observe([]job{historyJob(completed(1), completed(2))})
+	if got := counted(t, h); got != 2 {
+		t.Errorf("got %d builds counted, want the 2 builds after the reset", got)
+	}
+	if got := jobHistoryOf(h); got.Last != 2 {
+		t.Errorf("got last build %d, want 2", got.Last)
+	}
+}
+
+func TestHistoryStateFile(t *testing.T) {
+	setTestConfig(t, nil)
+	path := filepath.Join(t.TempDir(), "history.json")
+	h := newTestHistory(t, path)
+	h.observe([]job{historyJob(completed(1), running(2), completed(3))})
+
+	// Restart, the builds completed in between are counted
+	h = newTestHistory(t, path)
+	if got := jobHistoryOf(h); got.Last != 1 || !reflect.DeepEqual(got.Observed, []int{3}) {
+		t.Errorf("got history %+v read from the file, want last build 1 and 3 observed", got)
+	}
+	h.observe([]job{historyJob(completed(2), completed(3), completed(4))})
+	if got := counted(t, h); got != 2 {
+		t.Errorf("got %d builds counted after the restart, want 2 and 4", got)
+	}
+	h = newTestHistory(t, path)
+	if got := jobHistoryOf(h); got.Last != 4 || len(got.Observed) != 0 {
+		t.Errorf("got history %+v read from the file, want last build 4", got)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHistoryStore(path); err == nil {
+		t.Error("got no error for a broken state file")
+	}
+	if _, err := LoadHistoryStore(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("got error %v for a missing state file, want an empty history", err)
+	}
+}
@@ -0,0 +1,138 @@
+package exporter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// States of the folders in a crawl, also used as the "state" label of jenkins_crawl_folders
+const (
+	folderFetched     = "fetched"      // Downloaded
+	folderNotModified = "not_modified" // Jenkins answered 304 to the conditional request
+	folderReused      = "reused"       // Not requested, idle and fetched less than --crawl-idle-interval ago
+)
+
+var folderStates = []string{folderFetched, folderNotModified, folderReused}
+
+// validators Validators of a reply, sent back in the conditional requests
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// folderEntry Outcome of the last fetch of a folder
+type folderEntry struct {
+	validators validators
+	depth      int  // Levels of folders of the query
+	tooLarge   bool // The tree query of the folder is above --crawl-tree-max-bytes
+	fetched    time.Time
+	active     bool       // A job of the folder was building
+	jobs       []job      // Jobs kept by the filter, with their stages and test reports
+	folders    []*jFolder // Sub folders kept by the filter
+}
+
+// fresh Check if the folder can be reused without asking Jenkins
+func (e *folderEntry) fresh() bool {
+	return config.Global.CrawlIdleInterval > 0 && !e.active && time.Since(e.fetched) < config.Global.CrawlIdleInterval
+}
+
+// folderCache Folders of the previous crawls, to only download the ones that changed
+type folderCache struct {
+	mu      sync.Mutex
+	folders map[string]*folderEntry // By folder url
+
+	fetchedBytes prometheus.Gauge
+	states       *prometheus.GaugeVec
+	requests     prometheus.Gauge
+	duration     prometheus.Gauge
+}
+
+func newFolderCache() *folderCache {
+	return &folderCache{
+		folders: make(map[string]*folderEntry),
+		fetchedBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_crawl_fetched_bytes",
+				Help: "Size in bytes of the folder replies downloaded by the last crawl of Jenkins",
+			},
+		),
+		states: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_crawl_folders",
+				Help: "Number of folders of the last crawl of Jenkins by state: fetched, not_modified or reused",
+			},
+			[]string{
+				"state",
+			},
+		),
+		requests: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_crawl_requests",
+				Help: "Number of folder requests sent by the last crawl of Jenkins",
+			},
+		),
+		duration: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_crawl_duration_seconds",
+				Help: "Duration in seconds of the last crawl of Jenkins",
+			},
+		),
+	}
+}
+
+func (f *folderCache) get(url string) *folderEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.folders[url]
+}
+
+func (f *folderCache) put(url string, entry *folderEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.folders[url] = entry
+}
+
+// done Drop the folders missing from a successful crawl and update the metrics
+func (f *folderCache) done(visited map[string]struct{}, fetchedBytes int64, states map[string]int, requests int, duration time.Duration) {
+	f.mu.Lock()
+	for url := range f.folders {
+		if _, ok := visited[url]; !ok {
+			delete(f.folders, url)
+		}
+	}
+	f.mu.Unlock()
+	f.fetchedBytes.Set(float64(fetchedBytes))
+	f.requests.Set(float64(requests))
+	f.duration.Set(duration.Seconds())
+	for _, s := range folderStates {
+		f.states.WithLabelValues(s).Set(float64(states[s]))
+	}
+}
+
+func (f *folderCache) Describe(ch chan<- *prometheus.Desc) {
+	f.fetchedBytes.Describe(ch)
+	f.states.Describe(ch)
+	f.requests.Describe(ch)
+	f.duration.Describe(ch)
+}
+
+func (f *folderCache) Collect(ch chan<- prometheus.Metric) {
+	f.fetchedBytes.Collect(ch)
+	f.states.Collect(ch)
+	f.requests.Collect(ch)
+	f.duration.Collect(ch)
+}
+
+// Check if a job of the folder is building, its next fetch can't wait
+func isFolderActive(jobs []job) bool {
+	for i := range jobs {
+		if jobs[i].ColorPtr != nil && strings.HasSuffix(*jobs[i].ColorPtr, "_anime") {
+			return true
+		}
+	}
+	return false
+}
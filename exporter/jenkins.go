@@ -1,13 +1,13 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
-	"os"
 	"strings"
-	"time"
 
 	"github.com/goodbins/go-jenkins-exporter/config"
 	"github.com/sirupsen/logrus"
@@ -39,28 +39,29 @@ type jStatus struct {
 	Number    int    `json:"number"`
 	Timestamp int    `json:"timestamp"`
 	Result    string `json:"result"`
+	Building  bool   `json:"building"`
 }
 
 // Jenkins job struct
 type job struct {
-	Class                 string  `json:"_class"`
-	Name                  string  `json:"name"`
-	FullName              string  `json:"fullName"`
-	ColorPtr              *string `json:"color"`
-	URL                   string  `json:"url"`
-	LastBuild             jStatus `json:"lastBuild"`
-	LastCompletedBuild    jStatus `json:"lastCompletedBuild"`
-	LastFailedBuild       jStatus `json:"lastFailedBuild"`
-	LastStableBuild       jStatus `json:"lastStableBuild"`
-	LastSuccessfulBuild   jStatus `json:"lastSuccessfulBuild"`
-	LastUnstableBuild     jStatus `json:"lastUnstableBuild"`
-	LastUnsuccessfulBuild jStatus `json:"lastUnsuccessfulBuild"`
-}
-
-// Jenkins API response struct
-type JenkinsResponse struct {
-	Class string `json:"_class"`
-	Jobs  []job  `json:"jobs"`
+	Class                 string             `json:"_class"`
+	Name                  string             `json:"name"`
+	FullName              string             `json:"fullName"`
+	ColorPtr              *string            `json:"color"`
+	URL                   string             `json:"url"`
+	Disabled              bool               `json:"disabled"`
+	LastBuild             jStatus            `json:"lastBuild"`
+	LastCompletedBuild    jStatus            `json:"lastCompletedBuild"`
+	LastFailedBuild       jStatus            `json:"lastFailedBuild"`
+	LastStableBuild       jStatus            `json:"lastStableBuild"`
+	LastSuccessfulBuild   jStatus            `json:"lastSuccessfulBuild"`
+	LastUnstableBuild     jStatus            `json:"lastUnstableBuild"`
+	LastUnsuccessfulBuild jStatus            `json:"lastUnsuccessfulBuild"`
+	Stages                map[string]*jRun   `json:"-"` // Pipeline runs by status, see stageStatuses
+	Builds                []jStatus          `json:"builds"`
+	Jobs                  []job              `json:"jobs"` // Jobs of a folder, when included by a tree query
+	TestReport            *testReportSummary `json:"-"`    // Test report of the last completed build
+	Parent                *jFolder           `json:"-"`    // Folder holding the job, nil at the root
 }
 
 var jenkinsFolderClasses = []string{
@@ -69,39 +70,36 @@ var jenkinsFolderClasses = []string{
 	"org.jenkinsci.plugins.workflow.multibranch.WorkflowMultiBranchProject",
 	"org.jenkinsci.plugins.pipeline.multibranch.defaults.PipelineMultiBranchDefaultsProject"}
 
-func GetData() *[]job {
-	var jobsList []job                 // List of discovered jobs
-	var jobFolderLinks []string        // List of job folders
-	var jobFolderVisitedLinks []string // List of visited/explored folders
-	logrus.Debug("Get data from jenkins..")
-	walkAndGetJobs(getJenkinsApiUrl(), &jobsList, &jobFolderLinks, &jobFolderVisitedLinks)
-	logrus.Debug("Data retrieved successfully")
-	return &jobsList
-}
-
-// First url is the API's
-func walkAndGetJobs(url string, jobsList *[]job, jobFolderLinks *[]string, jobFolderVisitedLinks *[]string) {
-	logrus.Debug("Walking ", url)
-	jobs := requestJson(url + "api/json" + createQuery())
-	*jobFolderVisitedLinks = append(*jobFolderVisitedLinks, url)
-	updateJobsAndFolders(jobs, jobsList, jobFolderLinks)
-	for _, fL := range *jobFolderLinks {
-		if !isVisited(&fL, *jobFolderVisitedLinks) {
-			walkAndGetJobs(fL, jobsList, jobFolderLinks, jobFolderVisitedLinks)
-		}
-	}
+// jenkinsAPI Requests made to one Jenkins instance
+type jenkinsAPI struct {
+	instance *config.Instance
+	client   *Client
 }
 
-func updateJobsAndFolders(reply, jL *[]job, jF *[]string) {
-	for _, j := range *reply {
-		if j.Class != "" && isJobsFolder(&j.Class) ||
-			// Check for older version of the API that doesn't have class attribute
-			j.Class == "" && j.ColorPtr == nil {
-			*jF = append(*jF, j.URL)
-			continue
-		}
-		*jL = append(*jL, j)
+func newJenkinsAPI(instance *config.Instance) *jenkinsAPI {
+	return &jenkinsAPI{instance: instance, client: NewClient(instance)}
+}
+
+// GetData Crawl Jenkins and return the discovered jobs, the unchanged folders of cache are reused
+func (api *jenkinsAPI) GetData(ctx context.Context, filter *jobFilter, cache *folderCache) (*[]job, error) {
+	logrus.Debug("Get data from jenkins ", api.instance.Name, "..")
+	if config.Global.CrawlTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Global.CrawlTimeout)
+		defer cancel()
 	}
+	jobsList, err := newCrawler(api, filter, cache, config.Global.CrawlWorkers).crawl(ctx, api.getJenkinsApiUrl())
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debug("Data retrieved successfully")
+	return &jobsList, nil
+}
+
+func isFolder(j *job) bool {
+	return j.Class != "" && isJobsFolder(&j.Class) ||
+		// Check for older version of the API that doesn't have class attribute
+		j.Class == "" && j.ColorPtr == nil
 }
 
 func isJobsFolder(class *string) bool {
@@ -113,72 +111,146 @@ func isJobsFolder(class *string) bool {
 	return false
 }
 
-func isVisited(link *string, jobFolderVisitedLinks []string) bool {
-	for _, j := range jobFolderVisitedLinks {
-		if *link == j {
-			return true
+// requestJobsIfModified Request the jobs of url, sending the validators of the previous reply.
+// The jobs are passed to emit as they are decoded. Return the validators and the size of the reply,
+// or notModified when Jenkins answered 304. A reply above limit bytes fails with errReplyTooLarge, 0 for unlimited
+func (api *jenkinsAPI) requestJobsIfModified(ctx context.Context, url string, previous validators, limit int64, emit func(*job)) (next validators, size int64, notModified bool, err error) {
+	header := http.Header{}
+	if previous.etag != "" {
+		header.Set("If-None-Match", previous.etag)
+	}
+	if previous.lastModified != "" {
+		header.Set("If-Modified-Since", previous.lastModified)
+	}
+	resp, err := api.client.Do(ctx, http.MethodGet, url, header, nil)
+	if err != nil {
+		return next, 0, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return previous, 0, true, nil
+	}
+	next = validators{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+	body := &limitedReader{r: resp.Body, limit: limit}
+	err = streamJobs(json.NewDecoder(body), emit)
+	return next, body.n, false, body.check(url, err)
+}
+
+// streamJobs Decode the members of the jobs array of a reply one by one, skipping the other fields,
+// so that the whole reply is never held in memory
+func streamJobs(dec *json.Decoder, emit func(*job)) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key != "jobs" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			continue
+		}
+		if tok != json.Delim('[') {
+			return fmt.Errorf("unexpected %v at the start of the jobs", tok)
+		}
+		for dec.More() {
+			var j job
+			if err := dec.Decode(&j); err != nil {
+				return err
+			}
+			emit(&j)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
 		}
 	}
-	return false
+	return expectDelim(dec, '}')
 }
 
-func requestJson(url string) *[]job {
-	// Init a map whose keys are strings and whose values are themselves
-	// stored as empty interface values
-	var jResp JenkinsResponse
-	resp := request(url)
-	defer resp.Body.Close()
-	// Decode to json the jenkins reply
-	body, err := ioutil.ReadAll(resp.Body)
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = json.Unmarshal(body, &jResp)
+	if tok != delim {
+		return fmt.Errorf("unexpected %v, expecting %v", tok, delim)
+	}
+	return nil
+}
+
+// decodeJson Request url and decode the JSON reply into v
+func (api *jenkinsAPI) decodeJson(ctx context.Context, url string, v interface{}) error {
+	resp, err := api.request(ctx, url)
 	if err != nil {
-		logrus.Error("An error has occured while decoding JSON")
-		panic(err)
+		return err
 	}
-	return &jResp.Jobs
+	defer resp.Body.Close()
+	// Decode to json the jenkins reply
+	body := &limitedReader{r: resp.Body, limit: config.Global.MaxReplyBytes}
+	return body.check(url, json.NewDecoder(body).Decode(v))
 }
 
-func request(apiurl string) *http.Response {
-	// Init an http client
-	httpClient := &http.Client{Timeout: config.Global.JenkinsAPITimeout * time.Second}
-	// Init a http request, set basic auth and Do the request
-	req, err := http.NewRequest("GET", apiurl, nil)
-	// Test if credentials are used
-	if config.Global.JenkinsWithCreds {
-		if config.Global.JenkinsPassword != "" {
-			req.SetBasicAuth(config.Global.JenkinsUsername, config.Global.JenkinsPassword)
-		}
-		if config.Global.JenkinsToken != "" {
-			req.SetBasicAuth(config.Global.JenkinsUsername, config.Global.JenkinsToken)
-		}
+var errReplyTooLarge = errors.New("reply too large")
+
+// limitedReader Count the bytes read from a reply, and fail past limit bytes unless 0
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+	err   error // Error of the reply itself, as opposed to a decoding error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.err == errReplyTooLarge {
+		return 0, l.err
 	}
-	// Make the request
-	resp, err := httpClient.Do(req)
-	// Control the response code
-	logrus.Debug("Request HTTP response code ", resp.StatusCode)
-	if resp.StatusCode >= 400 {
-		logrus.Error("An error occured while requesting Jenkins. HTTP response code ", resp.StatusCode)
-		os.Exit(1)
+	// Read one byte past the limit at most, to tell a reply of limit bytes from a larger one
+	if l.limit > 0 && int64(len(p)) > l.limit-l.n+1 {
+		p = p[:l.limit-l.n+1]
 	}
-	// Panic if an error occurs
-	if err != nil {
-		logrus.Error("An error has occured when getting: ", apiurl)
-		panic(err)
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.limit > 0 && l.n > l.limit {
+		l.err = errReplyTooLarge
+		return n, l.err
+	}
+	if err != nil && err != io.EOF {
+		l.err = err
 	}
-	// Return the Jenskins response
-	return resp
+	return n, err
 }
 
-func getJenkinsApiUrl() string {
-	var apiurl string = "http://"
-	if config.Global.SSLOn {
-		apiurl = "https://"
+// check Turn the error of the decoding of the reply into a *JenkinsError
+func (l *limitedReader) check(url string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case l.err == errReplyTooLarge:
+		return &JenkinsError{Kind: ErrDecode, URL: url, Err: fmt.Errorf("%w, above %d bytes", errReplyTooLarge, l.limit)}
+	case l.err != nil:
+		return transportError(url, l.err)
+	default:
+		return &JenkinsError{Kind: ErrDecode, URL: url, Err: err}
 	}
-	apiurl += config.Global.JenkinsAPIHostPort + "/"
-	return apiurl
+}
+
+func (api *jenkinsAPI) request(ctx context.Context, apiurl string) (*http.Response, error) {
+	return api.client.Do(ctx, http.MethodGet, apiurl, nil, nil)
+}
+
+func (api *jenkinsAPI) getJenkinsApiUrl() string {
+	return api.client.BaseURL()
 }
 
 var jobStatuses = []string{
@@ -191,7 +263,8 @@ var jobStatuses = []string{
 	"lastUnsuccessfulBuild",
 }
 
-func createQuery() string {
+// createTreeQuery Return the query of the jobs of a folder and of its sub folders, down to depth levels of folders
+func createTreeQuery(depth int) string {
 
 	var jobStatusProperties string = `[
 		fullName,
@@ -212,8 +285,25 @@ func createQuery() string {
 	for _, s := range jobStatuses {
 		query += "," + s + jobStatusProperties
 	}
-	return strings.ReplaceAll(strings.ReplaceAll(
-		fmt.Sprintf("?tree=jobs[fullName,name,color,url%s]", query),
+	if config.Global.BuildHistory {
+		// Builds of the history, with the building state to skip the running ones
+		query += fmt.Sprintf(`,builds[
+			number,
+			result,
+			building,
+			duration,
+			actions[
+				causes[shortDescription],
+				queuingDurationMillis,
+				totalDurationMillis]]{0,%d}`, config.Global.HistoryBuilds)
+	}
+	fields := strings.ReplaceAll(strings.ReplaceAll(
+		"fullName,name,color,url,disabled"+query,
 		"\n", ""),
 		"\t", "")
+	tree := "jobs[" + fields + "]"
+	for i := 1; i < depth; i++ {
+		tree = "jobs[" + fields + "," + tree + "]"
+	}
+	return "?tree=" + tree
 }
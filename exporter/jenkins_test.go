@@ -0,0 +1,139 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// jobsReply Return a folder reply of n jobs with their last builds
+func jobsReply(t testing.TB, n int) []byte {
+	color := "blue"
+	build := jStatus{
+		Actions:   []jActions{{Causes: []jCauses{{ShortDescription: "Started by timer"}}}, {QueuingDurationMillis: 1500, TotalDurationMillis: 61500}},
+		Duration:  60000,
+		Number:    42,
+		Timestamp: 1700000000,
+		Result:    "SUCCESS",
+	}
+	reply := struct {
+		Class string `json:"_class"`
+		Jobs  []job  `json:"jobs"`
+	}{Class: "hudson.model.Hudson"}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprint("job-", i)
+		reply.Jobs = append(reply.Jobs, job{
+			Class:               "hudson.model.FreeStyleProject",
+			Name:                name,
+			FullName:            "team/" + name,
+			ColorPtr:            &color,
+			URL:                 "http://jenkins/job/team/job/" + name + "/",
+			LastBuild:           build,
+			LastCompletedBuild:  build,
+			LastStableBuild:     build,
+			LastSuccessfulBuild: build,
+		})
+	}
+	body, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestStreamJobs(t *testing.T) {
+	body := jobsReply(t, 50)
+	var want struct {
+		Jobs []job `json:"jobs"`
+	}
+	if err := json.Unmarshal(body, &want); err != nil {
+		t.Fatal(err)
+	}
+	var got []job
+	err := streamJobs(json.NewDecoder(bytes.NewReader(body)), func(j *job) {
+		got = append(got, *j)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want.Jobs) {
+		t.Error("streamed jobs differ from the unmarshalled ones")
+	}
+
+	for _, body := range []string{`{"jobs":null}`, `{"_class":"x","jobs":[]}`, `{}`} {
+		if err := streamJobs(json.NewDecoder(bytes.NewReader([]byte(body))), func(*job) { t.Errorf("job emitted for %s", body) }); err != nil {
+			t.Errorf("%s: %v", body, err)
+		}
+	}
+	if err := streamJobs(json.NewDecoder(bytes.NewReader([]byte(`{"jobs":{}}`))), func(*job) {}); err == nil {
+		t.Error("jobs object accepted")
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	body := jobsReply(t, 10)
+	size := int64(len(body))
+	for _, tt := range []struct {
+		limit   int64
+		tooLong bool
+	}{
+		{0, false},
+		{size, false},
+		{size - 1, true},
+		{size / 2, true},
+	} {
+		l := &limitedReader{r: bytes.NewReader(body), limit: tt.limit}
+		// A single read asking for the whole reply
+		_, err := ioutil.ReadAll(l)
+		if tooLong := l.err == errReplyTooLarge; tooLong != tt.tooLong || tooLong != (err == errReplyTooLarge) {
+			t.Errorf("limit %d of %d bytes: got error %v, want too large %v", tt.limit, size, err, tt.tooLong)
+		}
+		if tt.limit > 0 && l.n > tt.limit+1 {
+			t.Errorf("limit %d: read %d bytes, above the limit plus one", tt.limit, l.n)
+		}
+		if err := l.check("url", err); tt.tooLong != (errorKindOf(err) == ErrDecode) {
+			t.Errorf("limit %d: got %v", tt.limit, err)
+		}
+	}
+}
+
+// BenchmarkStreamJobs Decode the jobs one by one, see BenchmarkUnmarshalJobs for the whole reply at once
+func BenchmarkStreamJobs(b *testing.B) {
+	body := jobsReply(b, 5000)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		r := &limitedReader{r: bytes.NewReader(body)}
+		err := streamJobs(json.NewDecoder(r), func(j *job) {
+			count++
+		})
+		if err != nil || count != 5000 {
+			b.Fatalf("got %d jobs, error %v", count, err)
+		}
+	}
+}
+
+// BenchmarkUnmarshalJobs Read the whole reply and unmarshal it, as the crawl did before streamJobs
+func BenchmarkUnmarshalJobs(b *testing.B) {
+	body := jobsReply(b, 5000)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := ioutil.ReadAll(bytes.NewReader(body))
+		if err != nil {
+			b.Fatal(err)
+		}
+		var reply struct {
+			Jobs []job `json:"jobs"`
+		}
+		if err := json.Unmarshal(data, &reply); err != nil || len(reply.Jobs) != 5000 {
+			b.Fatalf("got %d jobs, error %v", len(reply.Jobs), err)
+		}
+	}
+}
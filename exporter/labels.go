@@ -0,0 +1,105 @@
+package exporter
+
+import (
+	"sync"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Jenkins folder struct, as walked by the crawler
+type jFolder struct {
+	Name     string
+	FullName string
+	URL      string
+	Class    string
+	Parent   *jFolder // Nil for the folders at the root of Jenkins
+}
+
+var multiBranchClasses = []string{
+	"org.jenkinsci.plugins.workflow.multibranch.WorkflowMultiBranchProject",
+	"org.jenkinsci.plugins.pipeline.multibranch.defaults.PipelineMultiBranchDefaultsProject"}
+
+// Labels added to the job series by --job-labels
+var folderLabelNames = []string{
+	"folder",
+	"project",
+	"branch",
+	"job_class",
+}
+
+// Labels identifying the job of a series
+var jobLabelNames = []string{"jobname"}
+
+var jobInfoDesc *prometheus.Desc
+
+var initMetricsOnce sync.Once
+
+// initJobMetrics Create the descriptions of the job series, once the configuration is loaded
+func initJobMetrics() {
+	if config.Global.JobLabels {
+		jobLabelNames = append(jobLabelNames, folderLabelNames...)
+	}
+	jobInfoDesc = prometheus.NewDesc(
+		"jenkins_job_info",
+		"Jenkins job url, folder, multibranch project, branch and class",
+		append([]string{"jobname", "url"}, folderLabelNames...),
+		nil,
+	)
+	initPrometheusMetrics()
+	initStageMetrics()
+	initTestReportMetrics()
+}
+
+// Return the job label names followed by the extra ones
+func jobLabels(extra ...string) []string {
+	return appendLabels(jobLabelNames, extra...)
+}
+
+// Return the job label values of the series of j
+func jobLabelValues(j *job) []string {
+	// Check for older version of the API that doesn't have this JSON attribute
+	jobName := j.FullName
+	if jobName == "" {
+		jobName = j.Name
+	}
+	if !config.Global.JobLabels {
+		return []string{jobName}
+	}
+	return append([]string{jobName}, folderLabelValues(j)...)
+}
+
+// Return the folder, project, branch and class of the job.
+// The branches of a multibranch project are jobs whose parent folder is the project
+func folderLabelValues(j *job) []string {
+	var folder, project, branch string
+	parent := j.Parent
+	if parent != nil && isMultiBranchProject(parent.Class) {
+		project = parent.Name
+		branch = j.Name
+		parent = parent.Parent
+	}
+	if parent != nil {
+		folder = parent.FullName
+	}
+	return []string{folder, project, branch, j.Class}
+}
+
+func isMultiBranchProject(class string) bool {
+	for _, c := range multiBranchClasses {
+		if class == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Return a copy of labels followed by the extra ones, labels is never modified
+func appendLabels(labels []string, extra ...string) []string {
+	return append(labels[:len(labels):len(labels)], extra...)
+}
+
+func collectJobInfo(ch chan<- prometheus.Metric, j *job) {
+	labels := append([]string{jobLabelValues(j)[0], j.URL}, folderLabelValues(j)...)
+	ch <- prometheus.MustNewConstMetric(jobInfoDesc, prometheus.GaugeValue, 1, labels...)
+}
@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// limiter Token bucket and cap on the requests in flight to a Jenkins instance.
+// A request is in flight until its body is closed
+type limiter struct {
+	tokens   *rate.Limiter // Nil without --limit.rate
+	inFlight chan struct{} // Nil without --limit.in-flight
+
+	wait    prometheus.Histogram
+	current prometheus.Gauge
+}
+
+// Limiters by Jenkins url, the collectors of a Jenkins instance and the probes targeting it share one
+var limiters = struct {
+	sync.Mutex
+	byURL map[string]*limiter
+}{byURL: make(map[string]*limiter)}
+
+// limiterFor Return the limiter of the Jenkins at baseURL
+func limiterFor(baseURL string) *limiter {
+	limiters.Lock()
+	defer limiters.Unlock()
+	if l, ok := limiters.byURL[baseURL]; ok {
+		return l
+	}
+	l := &limiter{
+		wait: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "jenkins_request_limiter_wait_seconds",
+				Help:    "Time spent by the requests to Jenkins waiting on the rate and in flight limits",
+				Buckets: []float64{.001, .01, .1, .5, 1, 2.5, 5, 10, 30},
+			},
+		),
+		current: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_requests_in_flight",
+				Help: "Number of requests to Jenkins in flight",
+			},
+		),
+	}
+	if config.Global.LimitRate > 0 {
+		burst := config.Global.LimitBurst
+		if burst < 1 {
+			burst = 1
+		}
+		l.tokens = rate.NewLimiter(rate.Limit(config.Global.LimitRate), burst)
+	}
+	if config.Global.LimitInFlight > 0 {
+		l.inFlight = make(chan struct{}, config.Global.LimitInFlight)
+	}
+	limiters.byURL[baseURL] = l
+	return l
+}
+
+// errLimited The request wasn't sent, the limits didn't let it through before the deadline.
+// It tells nothing about Jenkins, such requests are neither retried nor counted by the breaker
+var errLimited = errors.New("request not sent, the limits of Jenkins didn't let it through in time")
+
+// acquire Wait for a slot and a token, and return the function releasing the slot.
+// The errors wrap errLimited
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	start := time.Now()
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", errLimited, ctx.Err())
+		}
+	}
+	if l.tokens != nil {
+		if err := l.tokens.Wait(ctx); err != nil {
+			l.releaseSlot()
+			return nil, fmt.Errorf("%w: %v", errLimited, err)
+		}
+	}
+	l.wait.Observe(time.Since(start).Seconds())
+	l.current.Inc()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.current.Dec()
+			l.releaseSlot()
+		})
+	}, nil
+}
+
+func (l *limiter) releaseSlot() {
+	if l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+// Describe implements prometheus.Collector
+func (l *limiter) Describe(ch chan<- *prometheus.Desc) {
+	l.wait.Describe(ch)
+	l.current.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (l *limiter) Collect(ch chan<- prometheus.Metric) {
+	l.wait.Collect(ch)
+	l.current.Collect(ch)
+}
+
+// releasingBody Release the slot of the request when its body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
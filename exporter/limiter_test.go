@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLimiterWaitOutsideTimeout(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.JenkinsAPITimeout = 150 * time.Millisecond
+		c.LimitInFlight = 1
+		c.RetryMax = 3
+		c.BreakerFailures = 1
+	})
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	c := NewClient(f.instance())
+	// The last requests wait for the slot longer than the timeout of the instance
+	errs := make(chan error, 6)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- get(c)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := f.count("/api/json"); got != cap(errs) {
+		t.Errorf("got %d requests, want %d without retry", got, cap(errs))
+	}
+}
+
+func TestLimiterDeadline(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.LimitRate = 0.1
+		c.LimitBurst = 1
+		c.RetryMax = 3
+		c.BreakerFailures = 1
+	})
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	c := NewClient(f.instance())
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+	// The next token comes in 10s, after the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := c.Do(ctx, http.MethodGet, c.BaseURL()+"api/json", nil, nil)
+	if !errors.Is(err, errLimited) || errorKindOf(err) != ErrTimeout {
+		t.Errorf("got error %v, want a limiter timeout", err)
+	}
+	if got := f.count("/api/json"); got != 1 {
+		t.Errorf("got %d requests, want the first one only", got)
+	}
+	if got := testutil.ToFloat64(c.retries.WithLabelValues(retryTransport)); got != 0 {
+		t.Errorf("limited request retried %v times", got)
+	}
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	if got := c.breaker.state(); got != breakerClosed {
+		t.Errorf("got breaker %s, the limited request counted as a failure", got)
+	}
+}
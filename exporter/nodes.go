@@ -0,0 +1,239 @@
+package exporter
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Jenkins node label struct
+type jLabel struct {
+	Name string `json:"name"`
+}
+
+// Jenkins build running on an executor struct
+type jExecutable struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+}
+
+// Jenkins executor struct
+type jExecutor struct {
+	Idle              bool         `json:"idle"`
+	Number            int          `json:"number"`
+	CurrentExecutable *jExecutable `json:"currentExecutable"`
+}
+
+// Jenkins offline cause struct
+type jOfflineCause struct {
+	Class string `json:"_class"`
+}
+
+// Jenkins node monitors struct, values are null when the node is offline
+type jMonitorData struct {
+	DiskSpace *struct {
+		Size float64 `json:"size"`
+	} `json:"hudson.node_monitors.DiskSpaceMonitor"`
+	TemporarySpace *struct {
+		Size float64 `json:"size"`
+	} `json:"hudson.node_monitors.TemporarySpaceMonitor"`
+	SwapSpace *struct {
+		AvailablePhysicalMemory float64 `json:"availablePhysicalMemory"`
+		AvailableSwapSpace      float64 `json:"availableSwapSpace"`
+		TotalPhysicalMemory     float64 `json:"totalPhysicalMemory"`
+		TotalSwapSpace          float64 `json:"totalSwapSpace"`
+	} `json:"hudson.node_monitors.SwapSpaceMonitor"`
+	Clock *struct {
+		Diff float64 `json:"diff"`
+	} `json:"hudson.node_monitors.ClockMonitor"`
+	ResponseTime *struct {
+		Average float64 `json:"average"`
+	} `json:"hudson.node_monitors.ResponseTimeMonitor"`
+}
+
+// Jenkins node struct
+type jNode struct {
+	DisplayName        string         `json:"displayName"`
+	Offline            bool           `json:"offline"`
+	TemporarilyOffline bool           `json:"temporarilyOffline"`
+	OfflineCause       *jOfflineCause `json:"offlineCause"`
+	NumExecutors       int            `json:"numExecutors"`
+	AssignedLabels     []jLabel       `json:"assignedLabels"`
+	MonitorData        jMonitorData   `json:"monitorData"`
+	Executors          []jExecutor    `json:"executors"`
+}
+
+// Jenkins computer API response struct
+type jNodesResponse struct {
+	Computer []jNode `json:"computer"`
+}
+
+const nodesQuery = "?tree=computer[displayName,offline,temporarilyOffline,offlineCause[_class],numExecutors," +
+	"assignedLabels[name],monitorData[*],executors[idle,number,currentExecutable[number,url]]]"
+
+func newNodeDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc("jenkins_node_"+name, help, append([]string{"node"}, labels...), nil)
+}
+
+var (
+	nodeOnlineDesc             = newNodeDesc("online", "Whether the Jenkins node is online")
+	nodeTemporarilyOfflineDesc = newNodeDesc("temporarily_offline", "Whether the Jenkins node was put temporarily offline")
+	nodeOfflineCauseDesc       = newNodeDesc("offline_cause", "Cause of the Jenkins node being offline", "cause")
+	nodeExecutorsDesc          = newNodeDesc("executors", "Number of executors of the Jenkins node")
+	nodeBusyExecutorsDesc      = newNodeDesc("busy_executors", "Number of busy executors of the Jenkins node")
+	nodeIdleExecutorsDesc      = newNodeDesc("idle_executors", "Number of idle executors of the Jenkins node")
+	nodeInfoDesc               = newNodeDesc("info", "Labels of the Jenkins node", "labels")
+	nodeDiskSpaceDesc          = newNodeDesc("disk_space_bytes", "Free disk space of the Jenkins node workspace")
+	nodeTempSpaceDesc          = newNodeDesc("temp_space_bytes", "Free disk space of the Jenkins node temporary directory")
+	nodeMemoryAvailableDesc    = newNodeDesc("memory_available_bytes", "Available physical memory of the Jenkins node")
+	nodeMemoryTotalDesc        = newNodeDesc("memory_total_bytes", "Total physical memory of the Jenkins node")
+	nodeSwapAvailableDesc      = newNodeDesc("swap_available_bytes", "Available swap space of the Jenkins node")
+	nodeSwapTotalDesc          = newNodeDesc("swap_total_bytes", "Total swap space of the Jenkins node")
+	nodeClockDifferenceDesc    = newNodeDesc("clock_difference_seconds", "Clock difference between the Jenkins node and the controller")
+	nodeResponseTimeDesc       = newNodeDesc("response_time_seconds", "Average response time of the Jenkins node")
+	nodeExecutorBuildDesc      = newNodeDesc("executor_build_info", "Build currently running on the Jenkins node executor", "executor", "jobname", "number")
+)
+
+// nodesSource Nodes and executors of Jenkins
+type nodesSource struct {
+	api   *jenkinsAPI
+	mu    sync.RWMutex
+	nodes *[]jNode
+}
+
+func (s *nodesSource) name() string {
+	return "nodes"
+}
+
+func (s *nodesSource) fetch(ctx context.Context) error {
+	var nResp jNodesResponse
+	if err := s.api.decodeJson(ctx, s.api.getJenkinsApiUrl()+"computer/api/json"+nodesQuery, &nResp); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.nodes = &nResp.Computer
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *nodesSource) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeOnlineDesc
+	ch <- nodeTemporarilyOfflineDesc
+	ch <- nodeOfflineCauseDesc
+	ch <- nodeExecutorsDesc
+	ch <- nodeBusyExecutorsDesc
+	ch <- nodeIdleExecutorsDesc
+	ch <- nodeInfoDesc
+	ch <- nodeDiskSpaceDesc
+	ch <- nodeTempSpaceDesc
+	ch <- nodeMemoryAvailableDesc
+	ch <- nodeMemoryTotalDesc
+	ch <- nodeSwapAvailableDesc
+	ch <- nodeSwapTotalDesc
+	ch <- nodeClockDifferenceDesc
+	ch <- nodeResponseTimeDesc
+	ch <- nodeExecutorBuildDesc
+}
+
+func (s *nodesSource) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	nodes := s.nodes
+	s.mu.RUnlock()
+	if nodes == nil {
+		return
+	}
+	for _, n := range *nodes {
+		gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, append([]string{n.DisplayName}, labels...)...)
+		}
+		gauge(nodeOnlineDesc, b2F64(!n.Offline))
+		gauge(nodeTemporarilyOfflineDesc, b2F64(n.TemporarilyOffline))
+		if n.Offline && n.OfflineCause != nil {
+			gauge(nodeOfflineCauseDesc, 1, shortClassName(n.OfflineCause.Class))
+		}
+		gauge(nodeInfoDesc, 1, nodeLabels(&n))
+
+		busy := 0
+		for _, e := range n.Executors {
+			if e.Idle || e.CurrentExecutable == nil {
+				continue
+			}
+			busy++
+			gauge(nodeExecutorBuildDesc, 1, strconv.Itoa(e.Number), jobNameFromURL(e.CurrentExecutable.URL), strconv.Itoa(e.CurrentExecutable.Number))
+		}
+		gauge(nodeExecutorsDesc, i2F64(n.NumExecutors))
+		gauge(nodeBusyExecutorsDesc, i2F64(busy))
+		gauge(nodeIdleExecutorsDesc, i2F64(len(n.Executors)-busy))
+
+		m := n.MonitorData
+		if m.DiskSpace != nil {
+			gauge(nodeDiskSpaceDesc, m.DiskSpace.Size)
+		}
+		if m.TemporarySpace != nil {
+			gauge(nodeTempSpaceDesc, m.TemporarySpace.Size)
+		}
+		if m.SwapSpace != nil {
+			gauge(nodeMemoryAvailableDesc, m.SwapSpace.AvailablePhysicalMemory)
+			gauge(nodeMemoryTotalDesc, m.SwapSpace.TotalPhysicalMemory)
+			gauge(nodeSwapAvailableDesc, m.SwapSpace.AvailableSwapSpace)
+			gauge(nodeSwapTotalDesc, m.SwapSpace.TotalSwapSpace)
+		}
+		if m.Clock != nil {
+			gauge(nodeClockDifferenceDesc, m.Clock.Diff/1000)
+		}
+		if m.ResponseTime != nil {
+			gauge(nodeResponseTimeDesc, m.ResponseTime.Average/1000)
+		}
+	}
+}
+
+// Return the labels of the node, sorted and comma separated. The node name itself is left out
+func nodeLabels(n *jNode) string {
+	var labels []string
+	for _, l := range n.AssignedLabels {
+		if l.Name != n.DisplayName {
+			labels = append(labels, l.Name)
+		}
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+// Return the last part of a Java class name, ex: hudson.slaves.OfflineCause$UserCause -> OfflineCause$UserCause
+func shortClassName(class string) string {
+	return class[strings.LastIndex(class, ".")+1:]
+}
+
+// Return the full name of the job of a build url, ex: http://jenkins/job/f/job/b/12/ -> f/b
+func jobNameFromURL(buildURL string) string {
+	u, err := url.Parse(buildURL)
+	if err != nil {
+		return buildURL
+	}
+	var names []string
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "job" {
+			name, err := url.PathUnescape(parts[i+1])
+			if err != nil {
+				name = parts[i+1]
+			}
+			names = append(names, name)
+			// The name of the job may be job too
+			i++
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+func b2F64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
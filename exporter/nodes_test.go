@@ -0,0 +1,149 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestJobNameFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://jenkins/job/app/12/", "app"},
+		{"http://jenkins/job/f/job/b/12/", "f/b"},
+		{"http://jenkins/jenkins/job/f/job/b/12/", "f/b"},
+		{"http://jenkins/job/job/job/x/3/", "job/x"},
+		{"http://jenkins/job/f/job/job/1/", "f/job"},
+		{"http://jenkins/job/my%20app/1/", "my app"},
+		{"http://jenkins/job/pipeline/job/main/4/execution/node/3/", "pipeline/main"},
+		{"http://jenkins/computer/agent/", ""},
+	}
+	for _, tt := range tests {
+		if got := jobNameFromURL(tt.url); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+const nodesReply = `{"computer": [
+	{
+		"displayName": "built-in", "offline": false, "temporarilyOffline": false, "numExecutors": 2,
+		"assignedLabels": [{"name": "built-in"}, {"name": "linux"}, {"name": "docker"}],
+		"monitorData": {
+			"hudson.node_monitors.DiskSpaceMonitor": {"size": 1024},
+			"hudson.node_monitors.SwapSpaceMonitor": {"availablePhysicalMemory": 512, "totalPhysicalMemory": 2048, "availableSwapSpace": 0, "totalSwapSpace": 0},
+			"hudson.node_monitors.ClockMonitor": {"diff": 1500},
+			"hudson.node_monitors.ResponseTimeMonitor": {"average": 250}
+		},
+		"executors": [
+			{"idle": false, "number": 0, "currentExecutable": {"number": 12, "url": "http://jenkins/job/team/job/job/12/"}},
+			{"idle": true, "number": 1, "currentExecutable": null}
+		]
+	},
+	{
+		"displayName": "agent", "offline": true, "temporarilyOffline": true, "numExecutors": 1,
+		"offlineCause": {"_class": "hudson.slaves.OfflineCause$UserCause"},
+		"assignedLabels": [{"name": "agent"}],
+		"monitorData": {"hudson.node_monitors.DiskSpaceMonitor": null, "hudson.node_monitors.SwapSpaceMonitor": null},
+		"executors": [{"idle": true, "number": 0}]
+	}
+]}`
+
+// nodesJenkins Fake Jenkins with a single job and the nodes of nodesReply
+func nodesJenkins(t *testing.T) *fakeJenkins {
+	tree := fakeTree{"/": {{Name: "app"}}}
+	return newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/computer/api/json" {
+			w.Write([]byte(nodesReply))
+			return
+		}
+		tree.ServeHTTP(w, r)
+	}))
+}
+
+func TestNodes(t *testing.T) {
+	setTestConfig(t, nil)
+	f := nodesJenkins(t)
+	instance := f.instance()
+	instance.Nodes = true
+	c := NewJenkinsCollector(instance, nil)
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := `
+		# HELP jenkins_node_online Whether the Jenkins node is online
+		# TYPE jenkins_node_online gauge
+		jenkins_node_online{node="agent"} 0
+		jenkins_node_online{node="built-in"} 1
+		# HELP jenkins_node_temporarily_offline Whether the Jenkins node was put temporarily offline
+		# TYPE jenkins_node_temporarily_offline gauge
+		jenkins_node_temporarily_offline{node="agent"} 1
+		jenkins_node_temporarily_offline{node="built-in"} 0
+		# HELP jenkins_node_offline_cause Cause of the Jenkins node being offline
+		# TYPE jenkins_node_offline_cause gauge
+		jenkins_node_offline_cause{cause="OfflineCause$UserCause",node="agent"} 1
+		# HELP jenkins_node_info Labels of the Jenkins node
+		# TYPE jenkins_node_info gauge
+		jenkins_node_info{labels="",node="agent"} 1
+		jenkins_node_info{labels="docker,linux",node="built-in"} 1
+		# HELP jenkins_node_executors Number of executors of the Jenkins node
+		# TYPE jenkins_node_executors gauge
+		jenkins_node_executors{node="agent"} 1
+		jenkins_node_executors{node="built-in"} 2
+		# HELP jenkins_node_busy_executors Number of busy executors of the Jenkins node
+		# TYPE jenkins_node_busy_executors gauge
+		jenkins_node_busy_executors{node="agent"} 0
+		jenkins_node_busy_executors{node="built-in"} 1
+		# HELP jenkins_node_idle_executors Number of idle executors of the Jenkins node
+		# TYPE jenkins_node_idle_executors gauge
+		jenkins_node_idle_executors{node="agent"} 1
+		jenkins_node_idle_executors{node="built-in"} 1
+		# HELP jenkins_node_executor_build_info Build currently running on the Jenkins node executor
+		# TYPE jenkins_node_executor_build_info gauge
+		jenkins_node_executor_build_info{executor="0",jobname="team/job",node="built-in",number="12"} 1
+		# HELP jenkins_node_disk_space_bytes Free disk space of the Jenkins node workspace
+		# TYPE jenkins_node_disk_space_bytes gauge
+		jenkins_node_disk_space_bytes{node="built-in"} 1024
+		# HELP jenkins_node_memory_available_bytes Available physical memory of the Jenkins node
+		# TYPE jenkins_node_memory_available_bytes gauge
+		jenkins_node_memory_available_bytes{node="built-in"} 512
+		# HELP jenkins_node_memory_total_bytes Total physical memory of the Jenkins node
+		# TYPE jenkins_node_memory_total_bytes gauge
+		jenkins_node_memory_total_bytes{node="built-in"} 2048
+		# HELP jenkins_node_clock_difference_seconds Clock difference between the Jenkins node and the controller
+		# TYPE jenkins_node_clock_difference_seconds gauge
+		jenkins_node_clock_difference_seconds{node="built-in"} 1.5
+		# HELP jenkins_node_response_time_seconds Average response time of the Jenkins node
+		# TYPE jenkins_node_response_time_seconds gauge
+		jenkins_node_response_time_seconds{node="built-in"} 0.25
+	`
+	names := []string{
+		"jenkins_node_online", "jenkins_node_temporarily_offline", "jenkins_node_offline_cause", "jenkins_node_info",
+		"jenkins_node_executors", "jenkins_node_busy_executors", "jenkins_node_idle_executors", "jenkins_node_executor_build_info",
+		"jenkins_node_disk_space_bytes", "jenkins_node_temp_space_bytes", "jenkins_node_memory_available_bytes",
+		"jenkins_node_memory_total_bytes", "jenkins_node_clock_difference_seconds", "jenkins_node_response_time_seconds",
+	}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNodesDisabled(t *testing.T) {
+	setTestConfig(t, nil)
+	f := nodesJenkins(t)
+	c := NewJenkinsCollector(f.instance(), nil)
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.count("/computer/api/json"); got != 0 {
+		t.Errorf("nodes requested %d times, want none without the collector", got)
+	}
+	if got := testutil.CollectAndCount(c, "jenkins_node_online"); got != 0 {
+		t.Errorf("got %d node series, want none", got)
+	}
+}
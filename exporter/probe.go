@@ -0,0 +1,103 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultProbeModule = "default"
+
+// probeHandler Crawl the Jenkins given by the target parameter and serve only its metrics.
+// Targets must match one of the allowed targets, so that the exporter can't be used as an open proxy
+func probeHandler(allowedTargets []*regexp.Regexp) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		target := params.Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		u, normalized, err := parseProbeTarget(target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The allowed targets are matched against the url actually requested, not the raw parameter
+		if !isProbeTargetAllowed(normalized, allowedTargets) {
+			logrus.Warn("Refused probe of target not allowed: ", target)
+			http.Error(w, fmt.Sprintf("target %s is not allowed", target), http.StatusForbidden)
+			return
+		}
+		// Module names are case insensitive in the configuration file
+		moduleName := strings.ToLower(params.Get("module"))
+		if moduleName == "" {
+			moduleName = defaultProbeModule
+		}
+		module, ok := config.Global.ProbeModules[moduleName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %s", moduleName), http.StatusBadRequest)
+			return
+		}
+		instance := probeInstance(u, normalized, &module)
+
+		collector := NewJenkinsCollector(instance, nil)
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+		ctx, cancel := context.WithTimeout(r.Context(), scrapeTimeout(r, module.Timeout))
+		defer cancel()
+		// On error, jenkins_up is 0 and jenkins_scrape_errors_total tells why
+		collector.Refresh(ctx)
+		newPromHandler(registry).ServeHTTP(w, r)
+	})
+}
+
+func isProbeTargetAllowed(target string, allowedTargets []*regexp.Regexp) bool {
+	for _, t := range allowedTargets {
+		if t.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProbeTarget Parse the target, ex: https://jenkins.example.com/ci, and return it normalized to
+// scheme://host[:port][/path]. Credentials, queries and fragments are refused: they could hide
+// the host from the allowed targets, and they aren't part of the requested urls anyway
+func parseProbeTarget(target string) (*url.URL, string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" || u.Opaque != "" {
+		return nil, "", fmt.Errorf("invalid target %s, expected http(s)://host[:port][/path]", target)
+	}
+	if u.User != nil || u.RawQuery != "" || u.ForceQuery || u.Fragment != "" {
+		return nil, "", fmt.Errorf("invalid target %s, credentials, queries and fragments are not allowed", target)
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return u, u.Scheme + "://" + u.Host + u.Path, nil
+}
+
+// Build the instance to probe from the parsed target
+func probeInstance(u *url.URL, target string, module *config.ProbeModule) *config.Instance {
+	return &config.Instance{
+		Name:      target,
+		SSLOn:     u.Scheme == "https",
+		HostPort:  u.Host + u.Path,
+		Timeout:   module.Timeout,
+		Username:  module.Username,
+		Password:  module.Password,
+		Token:     module.Token,
+		WithCreds: module.Password != "" || module.Token != "",
+		Queue:     module.Queue,
+		Nodes:     module.Nodes,
+		TLS:       module.TLS,
+	}
+}
@@ -0,0 +1,114 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+)
+
+// probe Request the probe handler for the target and return the reply
+func probe(allowed []string, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	probeHandler(compileAnchored(allowed)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?target="+url.QueryEscape(target), nil))
+	return rec
+}
+
+func TestProbe(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.ProbeModules = map[string]config.ProbeModule{
+			defaultProbeModule: {Timeout: 5 * time.Second, Username: "probe", Token: "secret"},
+		}
+	})
+	var auth string
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		fakeTree{"/": {{Name: "a"}}}.ServeHTTP(w, r)
+	}))
+	allowed := []string{regexp.QuoteMeta(f.URL)}
+
+	// Allowed, with or without the trailing slash
+	for _, target := range []string{f.URL, f.URL + "/"} {
+		rec := probe(allowed, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want 200: %s", target, rec.Code, rec.Body)
+		}
+		if !regexp.MustCompile(`(?m)^jenkins_up(\{[^}]*\})? 1$`).MatchString(rec.Body.String()) {
+			t.Errorf("%s: jenkins_up 1 missing from the metrics:\n%s", target, rec.Body)
+		}
+	}
+	if auth == "" {
+		t.Error("credentials of the module not sent to the allowed target")
+	}
+	if got := f.count("/api/json"); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+
+	// Refused
+	if rec := probe(allowed, "http://jenkins.example.com"); rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d for a target not allowed, want 403", rec.Code)
+	}
+	if rec := probe(nil, f.URL); rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d without allowed targets, want 403", rec.Code)
+	}
+	if rec := probe(allowed, "ftp://"+strings.TrimPrefix(f.URL, "http://")); rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for a ftp target, want 400", rec.Code)
+	}
+}
+
+func TestProbeAllowedTargetBypass(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.ProbeModules = map[string]config.ProbeModule{
+			defaultProbeModule: {Timeout: 5 * time.Second, Username: "probe", Token: "secret"},
+		}
+	})
+	jenkins := newFakeJenkins(t, fakeTree{"/": {}})
+	evil := newFakeJenkins(t, fakeTree{"/": {}})
+	jenkinsHost := strings.TrimPrefix(jenkins.URL, "http://")
+	evilHost := strings.TrimPrefix(evil.URL, "http://")
+	// Matches any url holding the Jenkins address, as the raw target did
+	allowed := []string{"http://.*" + regexp.QuoteMeta(jenkinsHost) + "(/.*)?"}
+
+	for _, target := range []string{
+		evil.URL + "/?x=." + jenkinsHost,
+		evil.URL + "/#" + jenkinsHost,
+		"http://" + jenkinsHost + "@" + evilHost,
+		"http://user:pass@" + jenkinsHost,
+	} {
+		if rec := probe(allowed, target); rec.Code == http.StatusOK {
+			t.Errorf("%s: probe accepted", target)
+		}
+	}
+	if got := evil.count("/api/json"); got != 0 {
+		t.Errorf("got %d requests to the other host, the credentials leaked", got)
+	}
+}
+
+func TestProbeBreaker(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.BreakerFailures = 2
+		c.ProbeModules = map[string]config.ProbeModule{
+			defaultProbeModule: {Timeout: 5 * time.Second},
+		}
+	})
+	f := newFakeJenkins(t, statusHandler(http.StatusInternalServerError))
+	allowed := []string{regexp.QuoteMeta(f.URL)}
+	for i := 0; i < 4; i++ {
+		if rec := probe(allowed, f.URL); rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200 with jenkins_up 0: %s", rec.Code, rec.Body)
+		}
+	}
+	// The probes share the breaker of the target, it opened after the 2 failures
+	if got := f.count("/api/json"); got != 2 {
+		t.Errorf("got %d requests, want 2, none once the breaker opened", got)
+	}
+	rec := probe(allowed, f.URL+"/")
+	if !strings.Contains(rec.Body.String(), `jenkins_circuit_breaker_state{state="open"} 1`) {
+		t.Errorf("open breaker missing from the metrics:\n%s", rec.Body)
+	}
+}
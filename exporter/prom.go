@@ -3,163 +3,103 @@ package exporter
 import (
 	"regexp"
 	"strings"
-	"time"
 
-	"github.com/goodbins/go-jenkins-exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/sirupsen/logrus"
 )
 
-var prometheusMetrics map[string]*prometheus.GaugeVec
+var prometheusMetrics map[string]*prometheus.Desc
 
-func init() {
-	prometheusMetrics = make(map[string]*prometheus.GaugeVec)
+func initPrometheusMetrics() {
+	prometheusMetrics = make(map[string]*prometheus.Desc)
 	// Loop through statuses to create per status metrics
 	for _, s := range jobStatuses {
 		// Number
-		prometheusMetrics[s+"Number"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_number",
-				Help: "Jenkins build number for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"Number"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_number",
+			"Jenkins build number for "+s,
+			jobLabels(),
+			nil,
 		)
 		// Color
-		prometheusMetrics[s+"Color"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_color",
-				Help: "Jenkins build color for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"Color"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_color",
+			"Jenkins build color for "+s,
+			jobLabels(stateLabel("color")...),
+			nil,
 		)
 		// Result
-		prometheusMetrics[s+"Result"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_result",
-				Help: "Jenkins build result for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"Result"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_result",
+			"Jenkins build result for "+s,
+			jobLabels(stateLabel("result")...),
+			nil,
 		)
 		// Causes
-		prometheusMetrics[s+"Cause"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_cause",
-				Help: "Jenkins build cause for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"Cause"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_cause",
+			"Jenkins build cause for "+s,
+			jobLabels(stateLabel("cause")...),
+			nil,
 		)
 		// Duration
-		prometheusMetrics[s+"Duration"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_duration_seconds",
-				Help: "Jenkins build duration in seconds for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"Duration"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_duration_seconds",
+			"Jenkins build duration in seconds for "+s,
+			jobLabels(),
+			nil,
 		)
 		// Timestamp
-		prometheusMetrics[s+"Timestamp"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_timestamp_seconds",
-				Help: "Jenkins build timestamp in unixtime for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"Timestamp"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_timestamp_seconds",
+			"Jenkins build timestamp in unixtime for "+s,
+			jobLabels(),
+			nil,
 		)
 		// Queuing duration
-		prometheusMetrics[s+"QueuingDuration"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_queuing_duration_seconds",
-				Help: "Jenkins build queuing duration in seconds for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"QueuingDuration"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_queuing_duration_seconds",
+			"Jenkins build queuing duration in seconds for "+s,
+			jobLabels(),
+			nil,
 		)
 		// Total duration
-		prometheusMetrics[s+"TotalDuration"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_total_duration_seconds",
-				Help: "Jenkins build total duration in seconds for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"TotalDuration"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_total_duration_seconds",
+			"Jenkins build total duration in seconds for "+s,
+			jobLabels(),
+			nil,
 		)
 		// Skip counts
-		prometheusMetrics[s+"SkipCounts"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_skip_count",
-				Help: "Jenkins build skip counts for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"SkipCounts"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_skip_count",
+			"Jenkins build skip counts for "+s,
+			jobLabels(),
+			nil,
 		)
 		// Fail counts
-		prometheusMetrics[s+"FailCounts"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_fail_count",
-				Help: "Jenkins build fail counts for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"FailCounts"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_fail_count",
+			"Jenkins build fail counts for "+s,
+			jobLabels(),
+			nil,
 		)
 		// Pass counts
-		prometheusMetrics[s+"PassCounts"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_pass_count",
-				Help: "Jenkins build pass counts for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"PassCounts"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_pass_count",
+			"Jenkins build pass counts for "+s,
+			jobLabels(),
+			nil,
 		)
 		// Total counts
-		prometheusMetrics[s+"TotalCounts"] = promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "jenkins_job_" + toSnakeCase(s) + "_total_count",
-				Help: "Jenkins build total counts for " + s,
-			},
-			[]string{
-				"jobname",
-			},
+		prometheusMetrics[s+"TotalCounts"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_total_count",
+			"Jenkins build total counts for "+s,
+			jobLabels(),
+			nil,
 		)
 	}
 }
 
-// Get data from Jenkins and update prometheus metrics
-func SetGauges() {
-	logrus.Debug("Launching metrics update loop: updating rate is set to ", config.Global.MetricsUpdateRate)
-	for {
-		var jResp *[]job = GetData()
-		for _, job := range *jResp {
-			jobMetrics := prepareMetrics(&job)
-			for key, value := range jobMetrics {
-				// Check for older version of the API that doesn't have this JSON attribute
-				if job.FullName != "" {
-					prometheusMetrics[key].With(prometheus.Labels{"jobname": job.FullName}).Set(value)
-				} else {
-					prometheusMetrics[key].With(prometheus.Labels{"jobname": job.Name}).Set(value)
-				}
-			}
-		}
-		time.Sleep(config.Global.MetricsUpdateRate)
-	}
-}
-
 func prepareMetrics(job *job) map[string]float64 {
 	var jobMetrics = make(map[string]float64, 100)
 	// LastBuild
@@ -171,12 +111,7 @@ func prepareMetrics(job *job) map[string]float64 {
 	jobMetrics["lastBuildTimestamp"] = i2F64(job.LastBuild.Timestamp) / 1000.0
 	jobMetrics["lastBuildQueuingDuration"] = getTimeInQueueValue(job.LastBuild.Actions, "QueuingDuration")
 	jobMetrics["lastBuildTotalDuration"] = getTimeInQueueValue(job.LastBuild.Actions, "TotalDuration")
-	// if len(job.LastBuild.Actions) == 1 {
-	// jobMetrics["lastBuildSkipCount"] = i2F64(job.LastBuild.Actions[0].SkipCount)
-	// jobMetrics["lastBuildFailCount"] = i2F64(job.LastBuild.Actions[0].FailCount)
-	// jobMetrics["lastBuildTotalCount"] = i2F64(job.LastBuild.Actions[0].TotalCount)
-	// jobMetrics["lastBuildPassCount"] = i2F64(job.LastBuild.Actions[0].PassCount)
-	// }
+	setTestCounts(jobMetrics, "lastBuild", job.LastBuild.Actions)
 	// LastCompletedBuild
 	jobMetrics["lastCompletedBuildNumber"] = i2F64(job.LastCompletedBuild.Number)
 	jobMetrics["lastCompletedBuildResult"] = whichResult(job.LastCompletedBuild)
@@ -185,12 +120,7 @@ func prepareMetrics(job *job) map[string]float64 {
 	jobMetrics["lastCompletedBuildCause"] = whichCause(job.LastCompletedBuild)
 	jobMetrics["lastCompletedBuildQueuingDuration"] = getTimeInQueueValue(job.LastCompletedBuild.Actions, "QueuingDuration")
 	jobMetrics["lastCompletedBuildTotalDuration"] = getTimeInQueueValue(job.LastCompletedBuild.Actions, "TotalDuration")
-	// if len(job.LastCompletedBuild.Actions) == 1 {
-	// jobMetrics["lastCompletedBuildSkipCount"] = i2F64(job.LastCompletedBuild.Actions[0].SkipCount)
-	// jobMetrics["lastCompletedBuildFailCount"] = i2F64(job.LastCompletedBuild.Actions[0].FailCount)
-	// jobMetrics["lastCompletedBuildTotalCount"] = i2F64(job.LastCompletedBuild.Actions[0].TotalCount)
-	// jobMetrics["lastCompletedBuildPassCount"] = i2F64(job.LastCompletedBuild.Actions[0].PassCount)
-	// }
+	setTestCounts(jobMetrics, "lastCompletedBuild", job.LastCompletedBuild.Actions)
 	// LastFailedBuild
 	jobMetrics["lastFailedBuildNumber"] = i2F64(job.LastFailedBuild.Number)
 	jobMetrics["lastFailedBuildResult"] = whichResult(job.LastFailedBuild)
@@ -199,12 +129,7 @@ func prepareMetrics(job *job) map[string]float64 {
 	jobMetrics["lastFailedBuildCause"] = whichCause(job.LastFailedBuild)
 	jobMetrics["lastFailedBuildQueuingDuration"] = getTimeInQueueValue(job.LastFailedBuild.Actions, "QueuingDuration")
 	jobMetrics["lastFailedBuildTotalDuration"] = getTimeInQueueValue(job.LastFailedBuild.Actions, "TotalDuration")
-	// if len(job.LastFailedBuild.Actions) == 1 {
-	// jobMetrics["lastFailedBuildSkipCount"] = i2F64(job.LastFailedBuild.Actions[0].SkipCount)
-	// jobMetrics["lastFailedBuildFailCount"] = i2F64(job.LastFailedBuild.Actions[0].FailCount)
-	// jobMetrics["lastFailedBuildTotalCount"] = i2F64(job.LastFailedBuild.Actions[0].TotalCount)
-	// jobMetrics["lastFailedBuildPassCount"] = i2F64(job.LastFailedBuild.Actions[0].PassCount)
-	// }
+	setTestCounts(jobMetrics, "lastFailedBuild", job.LastFailedBuild.Actions)
 	// LastStableBuild
 	jobMetrics["lastStableBuildNumber"] = i2F64(job.LastStableBuild.Number)
 	jobMetrics["lastStableBuildResult"] = whichResult(job.LastStableBuild)
@@ -213,12 +138,7 @@ func prepareMetrics(job *job) map[string]float64 {
 	jobMetrics["lastStableBuildCause"] = whichCause(job.LastStableBuild)
 	jobMetrics["lastStableBuildQueuingDuration"] = getTimeInQueueValue(job.LastStableBuild.Actions, "QueuingDuration")
 	jobMetrics["lastStableBuildTotalDuration"] = getTimeInQueueValue(job.LastStableBuild.Actions, "TotalDuration")
-	// if len(job.LastStableBuild.Actions) == 1 {
-	// jobMetrics["lastStableBuildSkipCount"] = i2F64(job.LastStableBuild.Actions[0].SkipCount)
-	// jobMetrics["lastStableBuildFailCount"] = i2F64(job.LastStableBuild.Actions[0].FailCount)
-	// jobMetrics["lastStableBuildTotalCount"] = i2F64(job.LastStableBuild.Actions[0].TotalCount)
-	// jobMetrics["lastStableBuildPassCount"] = i2F64(job.LastStableBuild.Actions[0].PassCount)
-	// }
+	setTestCounts(jobMetrics, "lastStableBuild", job.LastStableBuild.Actions)
 	// LastSuccessfulBuild
 	jobMetrics["lastSuccessfulBuildNumber"] = i2F64(job.LastSuccessfulBuild.Number)
 	jobMetrics["lastSuccessfulBuildResult"] = whichResult(job.LastSuccessfulBuild)
@@ -227,12 +147,7 @@ func prepareMetrics(job *job) map[string]float64 {
 	jobMetrics["lastSuccessfulBuildCause"] = whichCause(job.LastSuccessfulBuild)
 	jobMetrics["lastSuccessfulBuildQueuingDuration"] = getTimeInQueueValue(job.LastSuccessfulBuild.Actions, "QueuingDuration")
 	jobMetrics["lastSuccessfulBuildTotalDuration"] = getTimeInQueueValue(job.LastSuccessfulBuild.Actions, "TotalDuration")
-	// if len(job.LastSuccessfulBuild.Actions) == 1 {
-	// jobMetrics["lastSuccessfulBuildSkipCount"] = i2F64(job.LastSuccessfulBuild.Actions[0].SkipCount)
-	// jobMetrics["lastSuccessfulBuildFailCount"] = i2F64(job.LastSuccessfulBuild.Actions[0].FailCount)
-	// jobMetrics["lastSuccessfulBuildTotalCount"] = i2F64(job.LastSuccessfulBuild.Actions[0].TotalCount)
-	// jobMetrics["lastSuccessfulBuildPassCount"] = i2F64(job.LastSuccessfulBuild.Actions[0].PassCount)
-	// }
+	setTestCounts(jobMetrics, "lastSuccessfulBuild", job.LastSuccessfulBuild.Actions)
 	// LastUnstableBuild
 	jobMetrics["lastUnstableBuildNumber"] = i2F64(job.LastUnstableBuild.Number)
 	jobMetrics["lastUnstableBuildResult"] = whichResult(job.LastUnstableBuild)
@@ -241,12 +156,7 @@ func prepareMetrics(job *job) map[string]float64 {
 	jobMetrics["lastUnstableBuildCause"] = whichCause(job.LastUnstableBuild)
 	jobMetrics["lastUnstableBuildQueuingDuration"] = getTimeInQueueValue(job.LastUnstableBuild.Actions, "QueuingDuration")
 	jobMetrics["lastUnstableBuildTotalDuration"] = getTimeInQueueValue(job.LastUnstableBuild.Actions, "TotalDuration")
-	// if len(job.LastUnstableBuild.Actions) == 1 {
-	// jobMetrics["lastUnstableBuildSkipCount"] = i2F64(job.LastUnstableBuild.Actions[0].SkipCount)
-	// jobMetrics["lastUnstableBuildFailCount"] = i2F64(job.LastUnstableBuild.Actions[0].FailCount)
-	// jobMetrics["lastUnstableBuildTotalCount"] = i2F64(job.LastUnstableBuild.Actions[0].TotalCount)
-	// jobMetrics["lastUnstableBuildPassCount"] = i2F64(job.LastUnstableBuild.Actions[0].PassCount)
-	// }
+	setTestCounts(jobMetrics, "lastUnstableBuild", job.LastUnstableBuild.Actions)
 	// LastUnsuccessfulBuild
 	jobMetrics["lastUnsuccessfulBuildNumber"] = i2F64(job.LastUnsuccessfulBuild.Number)
 	jobMetrics["lastUnsuccessfulBuildResult"] = whichResult(job.LastUnsuccessfulBuild)
@@ -255,66 +165,76 @@ func prepareMetrics(job *job) map[string]float64 {
 	jobMetrics["lastUnsuccessfulBuildCause"] = whichCause(job.LastUnsuccessfulBuild)
 	jobMetrics["lastUnsuccessfulBuildQueuingDuration"] = getTimeInQueueValue(job.LastUnsuccessfulBuild.Actions, "QueuingDuration")
 	jobMetrics["lastUnsuccessfulBuildTotalDuration"] = getTimeInQueueValue(job.LastUnsuccessfulBuild.Actions, "TotalDuration")
-	// if len(job.LastUnsuccessfulBuild.Actions) == 1 {
-	// jobMetrics["lastUnsuccessfulBuildSkipCount"] = i2F64(job.LastUnsuccessfulBuild.Actions[0].SkipCount)
-	// jobMetrics["lastUnsuccessfulBuildFailCount"] = i2F64(job.LastUnsuccessfulBuild.Actions[0].FailCount)
-	// jobMetrics["lastUnsuccessfulBuildTotalCount"] = i2F64(job.LastUnsuccessfulBuild.Actions[0].TotalCount)
-	// jobMetrics["lastUnsuccessfulBuildPassCount"] = i2F64(job.LastUnsuccessfulBuild.Actions[0].PassCount)
-	// }
+	setTestCounts(jobMetrics, "lastUnsuccessfulBuild", job.LastUnsuccessfulBuild.Actions)
 
 	return jobMetrics
 }
 
+// Legacy values of the colors, see whichColorState
+var colorValues = map[string]float64{
+	"blue":     0,
+	"red":      1,
+	"yellow":   2,
+	"notbuilt": 3,
+	"disabled": 4,
+	"aborted":  5,
+	"grey":     6,
+}
+
 func whichColor(color *string) float64 {
-	switch {
-	case color == nil:
+	state := whichColorState(color)
+	if state == "" {
 		// No value
 		return -1
-	case *color == "blue" || *color == "blue_anime":
-		return 0
-	case *color == "red" || *color == "red_anime":
-		return 1
-	case *color == "yellow" || *color == "yellow_anime":
-		return 2
-	case *color == "notbuilt" || *color == "notbuilt_anime":
-		return 3
-	case *color == "disabled" || *color == "disabled_anime":
-		return 4
-	case *color == "aborted" || *color == "aborted_anime":
-		return 5
-	case *color == "grey" || *color == "grey_anime":
-		return 6
-	default:
-		// Return for unknown values
-		return 100
 	}
+	if value, ok := colorValues[state]; ok {
+		return value
+	}
+	// Return for unknown values
+	return 100
 }
 
-func whichResult(build jStatus) float64 {
-	if build.Result == "FAILURE" {
-		return 0
+// Return the color without its _anime suffix, empty if there is none
+func whichColorState(color *string) string {
+	if color == nil {
+		return ""
 	}
-	if build.Result == "UNSTABLE" {
-		return 0.5
-	}
-	if build.Result == "SUCCESS" {
-		return 1
-	}
-	if build.Result == "ABORTED" {
-		return 2
-	}
-	// Return a value when the job has no build
-	if build.Timestamp == 0 || build.Result == "NOT_BUILT" {
-		return 3
-	}
-	// Return a value when the last job build is running
-	if build.Duration == 0 {
-		return 4
+	return strings.TrimSuffix(*color, "_anime")
+}
+
+// Legacy values of the results, see whichResultState
+var resultValues = map[string]float64{
+	"FAILURE":   0,
+	"UNSTABLE":  0.5,
+	"SUCCESS":   1,
+	"ABORTED":   2,
+	"NOT_BUILT": 3,
+	"RUNNING":   4,
+}
+
+func whichResult(build jStatus) float64 {
+	if value, ok := resultValues[whichResultState(build)]; ok {
+		return value
 	}
 	// Return for unknown values
 	return 100
 }
 
+func whichResultState(build jStatus) string {
+	switch {
+	case build.Result == "FAILURE" || build.Result == "UNSTABLE" || build.Result == "SUCCESS" || build.Result == "ABORTED":
+		return build.Result
+	// The job has no build
+	case build.Timestamp == 0 || build.Result == "NOT_BUILT":
+		return "NOT_BUILT"
+	// The last job build is running
+	case build.Duration == 0:
+		return "RUNNING"
+	default:
+		return build.Result
+	}
+}
+
 // Return action, by the class given in param
 func findActionByClass(actions []jActions, className string) *jActions {
 	for _, action := range actions {
@@ -335,7 +255,36 @@ func findOldCauseAction(actions []jActions) *jActions {
 	return nil
 }
 
+// Legacy values of the causes, see whichCauseState
+var causeValues = map[string]float64{
+	"timer":           0,
+	"user":            1,
+	"upstream":        2,
+	"scm":             3,
+	"branch_indexing": 4,
+	"gitlab_webhook":  5,
+	"cli":             6,
+	"remote":          7,
+	"replay":          8,
+	"restart":         9,
+	"branch_event":    10,
+}
+
 func whichCause(lastBuild jStatus) float64 {
+	state := whichCauseState(lastBuild)
+	if state == "" {
+		// Return a value if nil (ex: job with no build or no data)
+		return -1
+	}
+	if value, ok := causeValues[state]; ok {
+		return value
+	}
+	// Return another value for unknow value
+	return 100
+}
+
+// Return the cause of the build, empty if the API doesn't provide it and "unknown" if it isn't supported
+func whichCauseState(lastBuild jStatus) string {
 	causeAction := findActionByClass(lastBuild.Actions, "hudson.model.CauseAction")
 	// Case for newer API version
 	if causeAction != nil {
@@ -343,37 +292,36 @@ func whichCause(lastBuild jStatus) float64 {
 		switch {
 		// Started by timer or Started by timer with parameters
 		case desc == "hudson.triggers.TimerTrigger$TimerTriggerCause" || desc == "org.jenkinsci.plugins.parameterizedscheduler.ParameterizedTimerTriggerCause":
-			return 0
+			return "timer"
 		// Started by user
 		case desc == "hudson.model.Cause$UserIdCause" || desc == "au.com.centrumsystems.hudson.plugin.buildpipeline.BuildPipelineView$MyUserIdCause":
-			return 1
+			return "user"
 		// Started by upstream project
 		case desc == "hudson.model.Cause$UpstreamCause":
-			return 2
+			return "upstream"
 		case desc == "hudson.triggers.SCMTrigger$SCMTriggerCause":
-			return 3
+			return "scm"
 		case desc == "jenkins.branch.BranchIndexingCause":
-			return 4
+			return "branch_indexing"
 		case desc == "com.dabsquared.gitlabjenkins.cause.GitLabWebHookCause":
-			return 5
+			return "gitlab_webhook"
 		// Started from command line
 		case desc == "hudson.cli.BuildCommand$CLICause":
-			return 6
+			return "cli"
 		// Started by remote host
 		case desc == "hudson.model.Cause$RemoteCause":
-			return 7
+			return "remote"
 		// Replayed
 		case desc == "org.jenkinsci.plugins.workflow.cps.replay.ReplayCause":
-			return 8
+			return "replay"
 		// Restarted from build
 		case desc == "org.jenkinsci.plugins.pipeline.modeldefinition.causes.RestartDeclarativePipelineCause":
-			return 9
+			return "restart"
 		// Push event to branch or Merge request
 		case desc == "jenkins.branch.BranchEventCause":
-			return 10
+			return "branch_event"
 		default:
-			// Return another value for unknow value
-			return 100
+			return "unknown"
 		}
 	}
 	oldCauseAction := findOldCauseAction(lastBuild.Actions)
@@ -382,22 +330,49 @@ func whichCause(lastBuild jStatus) float64 {
 		desc := oldCauseAction.Causes[0].ShortDescription
 		switch {
 		case strings.HasPrefix(desc, "Started by timer"):
-			return 0
+			return "timer"
 		case strings.HasPrefix(desc, "Started by user"):
-			return 1
+			return "user"
 		case strings.HasPrefix(desc, "Started by upstream project"):
-			return 2
+			return "upstream"
 		case strings.HasPrefix(desc, "Started by an SCM change"):
-			return 3
+			return "scm"
 		case strings.HasPrefix(desc, "Started by remote host"):
-			return 7
+			return "remote"
 		default:
-			// Return another value for unknow value
-			return 100
+			return "unknown"
 		}
 	}
-	// Return a value if nil (ex: job with no build or no data)
-	return -1
+	return ""
+}
+
+// Test result actions, the aggregated ones come from matrix, maven or downstream builds
+var testResultClasses = []string{
+	"hudson.tasks.junit.TestResultAction",
+	"hudson.tasks.test.AggregatedTestResultAction",
+	"hudson.maven.reporters.SurefireAggregatedReport",
+}
+
+// Set the test counts of the build, if it has a test result action
+func setTestCounts(jobMetrics map[string]float64, status string, actions []jActions) {
+	var testAction *jActions
+	for _, class := range testResultClasses {
+		if testAction = findActionByClass(actions, class); testAction != nil {
+			break
+		}
+	}
+	if testAction == nil {
+		return
+	}
+	passCount := testAction.PassCount
+	// Jenkins doesn't always give the pass count
+	if passCount == 0 {
+		passCount = testAction.TotalCount - testAction.FailCount - testAction.SkipCount
+	}
+	jobMetrics[status+"SkipCounts"] = i2F64(testAction.SkipCount)
+	jobMetrics[status+"FailCounts"] = i2F64(testAction.FailCount)
+	jobMetrics[status+"TotalCounts"] = i2F64(testAction.TotalCount)
+	jobMetrics[status+"PassCounts"] = i2F64(passCount)
 }
 
 func getTimeInQueueValue(actions []jActions, value string) float64 {
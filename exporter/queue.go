@@ -0,0 +1,171 @@
+package exporter
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Jenkins queue item task struct
+type jQueueTask struct {
+	Name     string `json:"name"`
+	FullName string `json:"fullName"`
+	URL      string `json:"url"`
+}
+
+// Jenkins queue item struct
+type jQueueItem struct {
+	Class        string     `json:"_class"`
+	ID           int        `json:"id"`
+	Blocked      bool       `json:"blocked"`
+	Buildable    bool       `json:"buildable"`
+	Stuck        bool       `json:"stuck"`
+	Why          string     `json:"why"`
+	InQueueSince int64      `json:"inQueueSince"`
+	Task         jQueueTask `json:"task"`
+}
+
+// Jenkins queue API response struct
+type jQueueResponse struct {
+	Items []jQueueItem `json:"items"`
+}
+
+// Reasons an item is waiting in the queue
+const (
+	queueReasonStuck    = "stuck"
+	queueReasonBlocked  = "blocked"
+	queueReasonExecutor = "waiting_for_executor"
+	queueReasonBuild    = "buildable"
+	queueReasonOther    = "other"
+)
+
+var queueReasons = []string{queueReasonStuck, queueReasonBlocked, queueReasonExecutor, queueReasonBuild, queueReasonOther}
+
+const queueQuery = "?tree=items[id,blocked,buildable,stuck,why,inQueueSince,task[name,fullName,url]]"
+
+var (
+	queueItemsDesc = prometheus.NewDesc(
+		"jenkins_queue_items",
+		"Number of items in the Jenkins build queue",
+		nil,
+		nil,
+	)
+	queueReasonItemsDesc = prometheus.NewDesc(
+		"jenkins_queue_reason_items",
+		"Number of items in the Jenkins build queue by waiting reason",
+		[]string{
+			"reason",
+		},
+		nil,
+	)
+	queueLabelItemsDesc = prometheus.NewDesc(
+		"jenkins_queue_label_items",
+		"Number of items in the Jenkins build queue by node label",
+		[]string{
+			"label",
+		},
+		nil,
+	)
+	queueItemWaitDesc = prometheus.NewDesc(
+		"jenkins_queue_item_wait_seconds",
+		"Time spent in the Jenkins build queue by the item",
+		[]string{
+			"id",
+			"jobname",
+			"reason",
+		},
+		nil,
+	)
+)
+
+// The queue API doesn't expose the label an item waits for, it only appears in the why message:
+// "Waiting for next available executor on ‘linux’", "There are no nodes with the label ‘linux’"
+var queueLabelInWhy = regexp.MustCompile(`(?:executor on|label) ‘([^’]+)’`)
+
+// queueSource Items of the Jenkins build queue
+type queueSource struct {
+	api   *jenkinsAPI
+	mu    sync.RWMutex
+	items []jQueueItem
+	at    time.Time // Time of the fetch, used to compute the wait times
+}
+
+func (s *queueSource) name() string {
+	return "queue"
+}
+
+func (s *queueSource) fetch(ctx context.Context) error {
+	var qResp jQueueResponse
+	if err := s.api.decodeJson(ctx, s.api.getJenkinsApiUrl()+"queue/api/json"+queueQuery, &qResp); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.items = qResp.Items
+	s.at = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *queueSource) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueItemsDesc
+	ch <- queueReasonItemsDesc
+	ch <- queueLabelItemsDesc
+	ch <- queueItemWaitDesc
+}
+
+func (s *queueSource) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	items, at := s.items, s.at
+	s.mu.RUnlock()
+	if at.IsZero() {
+		return
+	}
+
+	byReason := make(map[string]int, len(queueReasons))
+	for _, r := range queueReasons {
+		byReason[r] = 0
+	}
+	byLabel := make(map[string]int)
+	for _, item := range items {
+		reason := whichQueueReason(&item)
+		byReason[reason]++
+		if m := queueLabelInWhy.FindStringSubmatch(item.Why); m != nil {
+			byLabel[m[1]]++
+		}
+		jobName := item.Task.FullName
+		if jobName == "" {
+			jobName = item.Task.Name
+		}
+		wait := at.Sub(time.Unix(0, item.InQueueSince*int64(time.Millisecond))).Seconds()
+		ch <- prometheus.MustNewConstMetric(queueItemWaitDesc, prometheus.GaugeValue, wait, strconv.Itoa(item.ID), jobName, reason)
+	}
+
+	ch <- prometheus.MustNewConstMetric(queueItemsDesc, prometheus.GaugeValue, float64(len(items)))
+	for reason, n := range byReason {
+		ch <- prometheus.MustNewConstMetric(queueReasonItemsDesc, prometheus.GaugeValue, float64(n), reason)
+	}
+	for label, n := range byLabel {
+		ch <- prometheus.MustNewConstMetric(queueLabelItemsDesc, prometheus.GaugeValue, float64(n), label)
+	}
+}
+
+func whichQueueReason(item *jQueueItem) string {
+	switch {
+	case item.Stuck:
+		return queueReasonStuck
+	case item.Blocked:
+		return queueReasonBlocked
+	case item.Buildable && strings.Contains(item.Why, "executor"):
+		return queueReasonExecutor
+	case item.Buildable:
+		return queueReasonBuild
+	default:
+		// Waiting items, like the ones in their quiet period
+		return queueReasonOther
+	}
+}
@@ -0,0 +1,127 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueueReason(t *testing.T) {
+	tests := []struct {
+		name string
+		item jQueueItem
+		want string
+	}{
+		{"stuck", jQueueItem{Stuck: true, Buildable: true, Why: "There are no nodes with the label ‘windows’"}, queueReasonStuck},
+		{"stuck and blocked", jQueueItem{Stuck: true, Blocked: true}, queueReasonStuck},
+		{"blocked", jQueueItem{Blocked: true, Why: "Build #12 is already in progress (ETA: 3 min 2 sec)"}, queueReasonBlocked},
+		{"waiting for executor", jQueueItem{Buildable: true, Why: "Waiting for next available executor on ‘linux’"}, queueReasonExecutor},
+		{"waiting for any executor", jQueueItem{Buildable: true, Why: "Waiting for next available executor"}, queueReasonExecutor},
+		{"buildable", jQueueItem{Buildable: true, Why: "There are no nodes with the label ‘linux’"}, queueReasonBuild},
+		{"quiet period", jQueueItem{Why: "In the quiet period. Expires in 4.9 sec"}, queueReasonOther},
+		{"no reason", jQueueItem{}, queueReasonOther},
+	}
+	for _, tt := range tests {
+		if got := whichQueueReason(&tt.item); got != tt.want {
+			t.Errorf("%s: got reason %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueueLabelInWhy(t *testing.T) {
+	tests := []struct {
+		why  string
+		want string
+	}{
+		{"Waiting for next available executor on ‘linux’", "linux"},
+		{"Waiting for next available executor on ‘linux && docker’", "linux && docker"},
+		{"There are no nodes with the label ‘windows’", "windows"},
+		{"‘agent-1’ is offline", ""},
+		{"Waiting for next available executor", ""},
+		{"In the quiet period. Expires in 4.9 sec", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if m := queueLabelInWhy.FindStringSubmatch(tt.why); m != nil {
+			got = m[1]
+		}
+		if got != tt.want {
+			t.Errorf("%q: got label %q, want %q", tt.why, got, tt.want)
+		}
+	}
+}
+
+const queueReply = `{"items": [
+	{"id": 1, "buildable": true, "stuck": true, "why": "There are no nodes with the label ‘windows’", "inQueueSince": 1700000000000, "task": {"name": "win", "fullName": "team/win"}},
+	{"id": 2, "blocked": true, "why": "Build #12 is already in progress", "inQueueSince": 1700000000000, "task": {"name": "app", "fullName": "app"}},
+	{"id": 3, "buildable": true, "why": "Waiting for next available executor on ‘linux’", "inQueueSince": 1700000000000, "task": {"name": "lint", "fullName": "team/lint"}},
+	{"id": 4, "buildable": true, "why": "Waiting for next available executor on ‘linux’", "inQueueSince": 1700000000000, "task": {"name": "test"}}
+]}`
+
+func TestQueue(t *testing.T) {
+	setTestConfig(t, nil)
+	tree := fakeTree{"/": {{Name: "app"}}}
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/queue/api/json" {
+			w.Write([]byte(queueReply))
+			return
+		}
+		tree.ServeHTTP(w, r)
+	}))
+	instance := f.instance()
+	instance.Queue = true
+	c := NewJenkinsCollector(instance, nil)
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := `
+		# HELP jenkins_queue_items Number of items in the Jenkins build queue
+		# TYPE jenkins_queue_items gauge
+		jenkins_queue_items 4
+		# HELP jenkins_queue_reason_items Number of items in the Jenkins build queue by waiting reason
+		# TYPE jenkins_queue_reason_items gauge
+		jenkins_queue_reason_items{reason="blocked"} 1
+		jenkins_queue_reason_items{reason="buildable"} 0
+		jenkins_queue_reason_items{reason="other"} 0
+		jenkins_queue_reason_items{reason="stuck"} 1
+		jenkins_queue_reason_items{reason="waiting_for_executor"} 2
+		# HELP jenkins_queue_label_items Number of items in the Jenkins build queue by node label
+		# TYPE jenkins_queue_label_items gauge
+		jenkins_queue_label_items{label="linux"} 2
+		jenkins_queue_label_items{label="windows"} 1
+	`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "jenkins_queue_items", "jenkins_queue_reason_items", "jenkins_queue_label_items"); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.CollectAndCount(c, "jenkins_queue_item_wait_seconds"); got != 4 {
+		t.Errorf("got %d wait times, want one per item", got)
+	}
+}
+
+func TestQueueWait(t *testing.T) {
+	var qResp jQueueResponse
+	if err := json.Unmarshal([]byte(queueReply), &qResp); err != nil {
+		t.Fatal(err)
+	}
+	// Fetched 90s after the items were queued
+	s := &queueSource{items: qResp.Items[2:], at: time.Unix(1700000090, 0)}
+	want := `
+		# HELP jenkins_queue_item_wait_seconds Time spent in the Jenkins build queue by the item
+		# TYPE jenkins_queue_item_wait_seconds gauge
+		jenkins_queue_item_wait_seconds{id="3",jobname="team/lint",reason="waiting_for_executor"} 90
+		jenkins_queue_item_wait_seconds{id="4",jobname="test",reason="waiting_for_executor"} 90
+	`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(want), "jenkins_queue_item_wait_seconds"); err != nil {
+		t.Error(err)
+	}
+	// Not fetched yet
+	if got := testutil.CollectAndCount(&queueSource{}); got != 0 {
+		t.Errorf("got %d series before the first fetch, want none", got)
+	}
+}
@@ -0,0 +1,190 @@
+package exporter
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons of the retries, also used as the "reason" label of jenkins_request_retries_total
+const (
+	retryTransport = "transport" // Connection error or timeout
+	retryThrottled = "throttled" // 429 Too Many Requests
+	retryServer    = "server"    // 5xx
+)
+
+var retryReasons = []string{retryTransport, retryThrottled, retryServer}
+
+// Return why the attempt should be retried, "" when it succeeded or failed for good
+func retryReason(resp *http.Response, err error) string {
+	var jErr *JenkinsError
+	switch {
+	case errors.As(err, &jErr) && jErr.StatusCode != 0:
+		// Refused crumb request
+		return ""
+	case err != nil:
+		return retryTransport
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return retryThrottled
+	case resp.StatusCode >= 500:
+		return retryServer
+	}
+	return ""
+}
+
+// retryDelay Return the delay before the next attempt: the Retry-After of the reply when given,
+// else an exponential backoff with jitter, between half and all of config.Global.RetryBackoff * 2^attempt
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return delay
+		}
+	}
+	backoff := config.Global.RetryMaxBackoff
+	if attempt < 30 {
+		if b := config.Global.RetryBackoff << uint(attempt); b > 0 && b < backoff {
+			backoff = b
+		}
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Parse a Retry-After header, given in seconds or as an HTTP date
+func parseRetryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// Circuit breaker states, also used as the "state" label of jenkins_circuit_breaker_state
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+var breakerStates = []string{breakerClosed, breakerOpen, breakerHalfOpen}
+
+var errBreakerOpen = errors.New("circuit breaker open, Jenkins failed too many requests in a row")
+
+// Outcomes of the requests let through by the breaker
+type breakerOutcome int
+
+const (
+	breakerSuccess breakerOutcome = iota
+	breakerFailure
+	breakerIgnored // Cancelled by the caller, tells nothing about Jenkins
+)
+
+// breaker Circuit breaker of a Jenkins instance. It opens after config.Global.BreakerFailures
+// failed requests in a row and refuses every request for config.Global.BreakerCooldown.
+// Then a single trial request is let through, closing it on success and opening it again on failure
+type breaker struct {
+	name string
+
+	mu       sync.Mutex
+	failures int       // Failed requests in a row
+	openedAt time.Time // Zero while closed
+	trial    bool      // The trial request of the half open breaker is in progress
+	opened   int       // Number of times the breaker opened
+}
+
+// Breakers by Jenkins url, the collectors of a Jenkins instance and the probes targeting it share one,
+// so that the failures of a Jenkins add up across the probes
+var breakers = struct {
+	sync.Mutex
+	byURL map[string]*breaker
+}{byURL: make(map[string]*breaker)}
+
+// breakerFor Return the breaker of the Jenkins at baseURL, named after the instance creating it
+func breakerFor(baseURL, name string) *breaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+	if b, ok := breakers.byURL[baseURL]; ok {
+		return b
+	}
+	b := &breaker{name: name}
+	breakers.byURL[baseURL] = b
+	return b
+}
+
+// allow Return errBreakerOpen when the request must not be sent, else the request must be followed by record,
+// given trial. trial is true for the trial request of the half open breaker, only its outcome closes the breaker
+func (b *breaker) allow() (trial bool, err error) {
+	if config.Global.BreakerFailures <= 0 {
+		return false, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state() {
+	case breakerOpen:
+		return false, errBreakerOpen
+	case breakerHalfOpen:
+		if b.trial {
+			return false, errBreakerOpen
+		}
+		b.trial = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *breaker) record(trial bool, outcome breakerOutcome) {
+	if config.Global.BreakerFailures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if trial {
+		b.trial = false
+	} else if !b.openedAt.IsZero() {
+		// Sent before the breaker opened, the trial request decides
+		return
+	}
+	switch outcome {
+	case breakerSuccess:
+		if !b.openedAt.IsZero() {
+			logrus.Info("Jenkins ", b.name, " answered again, closing the circuit breaker")
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+	case breakerFailure:
+		b.failures++
+		if trial || b.failures >= config.Global.BreakerFailures {
+			if b.openedAt.IsZero() {
+				logrus.Warn("Jenkins ", b.name, " failed ", b.failures, " requests in a row, opening the circuit breaker for ", config.Global.BreakerCooldown)
+				b.opened++
+			}
+			b.openedAt = time.Now()
+		}
+	}
+}
+
+// state Return the state of the breaker, b.mu must be held
+func (b *breaker) state() string {
+	switch {
+	case b.openedAt.IsZero():
+		return breakerClosed
+	case time.Since(b.openedAt) < config.Global.BreakerCooldown:
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
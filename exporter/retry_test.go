@@ -0,0 +1,210 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// flakyReply Status and Retry-After header of a reply of a flaky server
+type flakyReply struct {
+	code       int
+	retryAfter string
+}
+
+// flaky Reply to the requests in turn, the last reply being repeated. Replies can be changed with set
+type flaky struct {
+	mu      sync.Mutex
+	replies []flakyReply
+}
+
+func (f *flaky) set(replies ...flakyReply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = replies
+}
+
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	reply := f.replies[0]
+	if len(f.replies) > 1 {
+		f.replies = f.replies[1:]
+	}
+	f.mu.Unlock()
+	if reply.retryAfter != "" {
+		w.Header().Set("Retry-After", reply.retryAfter)
+	}
+	w.WriteHeader(reply.code)
+	w.Write([]byte(`{}`))
+}
+
+func get(c *Client) error {
+	resp, err := c.Do(context.Background(), http.MethodGet, c.BaseURL()+"api/json", nil, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestRetry(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.RetryMax = 3
+	})
+	server := &flaky{}
+	server.set(flakyReply{code: 503}, flakyReply{code: 429}, flakyReply{code: 500}, flakyReply{code: 200})
+	f := newFakeJenkins(t, server)
+	c := NewClient(f.instance())
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.count("/api/json"); got != 4 {
+		t.Errorf("got %d requests, want 4", got)
+	}
+	for reason, want := range map[string]float64{retryServer: 2, retryThrottled: 1, retryTransport: 0} {
+		if got := testutil.ToFloat64(c.retries.WithLabelValues(reason)); got != want {
+			t.Errorf("got jenkins_request_retries_total{reason=%q} %v, want %v", reason, got, want)
+		}
+	}
+
+	// Out of retries
+	server.set(flakyReply{code: 502})
+	if err := get(c); errorKindOf(err) != ErrServer {
+		t.Errorf("got error %v, want a server error", err)
+	}
+	if got := f.count("/api/json"); got != 8 {
+		t.Errorf("got %d requests, want 4 more", got)
+	}
+	// Not found isn't retried
+	server.set(flakyReply{code: 404})
+	if err := get(c); errorKindOf(err) != ErrNotFound {
+		t.Errorf("got error %v, want a not found error", err)
+	}
+	if got := f.count("/api/json"); got != 9 {
+		t.Errorf("got %d requests, want 1 more", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.RetryMax = 1
+		c.RetryMaxBackoff = 2 * time.Second
+	})
+	server := &flaky{}
+	server.set(flakyReply{code: 429, retryAfter: "1"}, flakyReply{code: 200})
+	f := newFakeJenkins(t, server)
+	c := NewClient(f.instance())
+	start := time.Now()
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the 1s of Retry-After", elapsed)
+	}
+
+	// Delays above the max backoff aren't waited
+	server.set(flakyReply{code: 503, retryAfter: "60"}, flakyReply{code: 200})
+	start = time.Now()
+	if err := get(c); errorKindOf(err) != ErrServer {
+		t.Errorf("got error %v, want a server error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %v for a Retry-After above the max backoff", elapsed)
+	}
+	if got := f.count("/api/json"); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.BreakerFailures = 3
+		c.BreakerCooldown = 50 * time.Millisecond
+	})
+	server := &flaky{}
+	server.set(flakyReply{code: 500})
+	f := newFakeJenkins(t, server)
+	c := NewClient(f.instance())
+	state := func() string {
+		c.breaker.mu.Lock()
+		defer c.breaker.mu.Unlock()
+		return c.breaker.state()
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := get(c); errorKindOf(err) != ErrServer {
+			t.Fatalf("request %d: got error %v, want a server error", i, err)
+		}
+	}
+	if err := get(c); errorKindOf(err) != ErrUnavailable {
+		t.Errorf("got error %v, want the breaker open", err)
+	}
+	if got := f.count("/api/json"); got != 3 {
+		t.Errorf("got %d requests, want 3, none while open", got)
+	}
+
+	// Failed trial
+	time.Sleep(60 * time.Millisecond)
+	if got := state(); got != breakerHalfOpen {
+		t.Fatalf("got state %s after the cooldown, want %s", got, breakerHalfOpen)
+	}
+	if err := get(c); errorKindOf(err) != ErrServer {
+		t.Errorf("got error %v, want the server error of the trial", err)
+	}
+	if got := state(); got != breakerOpen {
+		t.Errorf("got state %s after a failed trial, want %s", got, breakerOpen)
+	}
+
+	// Successful trial
+	server.set(flakyReply{code: 200})
+	time.Sleep(60 * time.Millisecond)
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+	if got := state(); got != breakerClosed {
+		t.Errorf("got state %s after a successful trial, want %s", got, breakerClosed)
+	}
+	if got := f.count("/api/json"); got != 5 {
+		t.Errorf("got %d requests, want 5", got)
+	}
+}
+
+func TestBreakerLateRequests(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.BreakerFailures = 1
+		c.BreakerCooldown = 10 * time.Millisecond
+	})
+	b := &breaker{name: "test"}
+	allow := func() bool {
+		trial, err := b.allow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return trial
+	}
+	late := allow()
+	b.record(allow(), breakerFailure)
+	if _, err := b.allow(); err != errBreakerOpen {
+		t.Fatalf("got %v, want the breaker open", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	trial := allow()
+	if !trial {
+		t.Fatal("no trial token after the cooldown")
+	}
+	// A request sent before the breaker opened doesn't end the trial
+	b.record(late, breakerSuccess)
+	if _, err := b.allow(); err != errBreakerOpen {
+		t.Errorf("got %v, want a single trial request", err)
+	}
+	b.record(trial, breakerSuccess)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if got := b.state(); got != breakerClosed {
+		t.Errorf("got state %s after the trial, want %s", got, breakerClosed)
+	}
+}
@@ -1,34 +1,183 @@
 package exporter
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/goodbins/go-jenkins-exporter/config"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/sirupsen/logrus"
 )
 
-// Serve serves the metrics, helthcheck /ping and a redirection on /
-func Serve() {
+// Margin kept between the scrape timeout and the crawl deadline to let the reply reach Prometheus
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// Serve serves the metrics, helthchecks /ping, /-/healthy and /-/ready and a redirection on /, until ctx is done.
+// The update loops then stop and the scrapes in progress are given config.Global.ShutdownTimeout to complete
+func Serve(ctx context.Context) error {
 	// Print start message
 	logrus.Info("Starting go-jenkins-exporter")
 
-	// Launch metrics update go routine
-	go SetGauges()
+	// Register the collectors on a private registry, one per Jenkins instance
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	var history *HistoryStore
+	if config.Global.BuildHistory {
+		var err error
+		if history, err = LoadHistoryStore(config.Global.HistoryStateFile); err != nil {
+			return fmt.Errorf("error reading the build history %s: %w", config.Global.HistoryStateFile, err)
+		}
+	}
+	// Context of the update loops
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var loops sync.WaitGroup
+	var jenkinsCollectors []*JenkinsCollector
+	for i := range config.Global.Instances {
+		instance := &config.Global.Instances[i]
+		collector := NewJenkinsCollector(instance, history)
+		prometheus.WrapRegistererWith(prometheus.Labels{"instance": instance.Name}, registry).MustRegister(collector)
+		jenkinsCollectors = append(jenkinsCollectors, collector)
+
+		// Launch metrics update go routine
+		if config.Global.CollectionMode == ModeCached {
+			loops.Add(1)
+			go func() {
+				defer loops.Done()
+				collector.Run(ctx)
+			}()
+		}
+	}
 
-	// Handle routes: / /ping /metrics
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// Handle routes: / /ping /-/healthy /-/ready /metrics
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 		<head><title>Go Jenkins Exporter</title></head>
 		<body>
 		<h1>Go Jenkins Exporter</h1>
 		<p><a href="` + config.Global.MetricsPath + `">Metrics</a></p>
+		<p><a href="/-/ready">Readiness</a></p>
 		</body></html>`))
 	})
-	http.HandleFunc("/ping", Ping)
-	http.Handle(config.Global.MetricsPath, promhttp.Handler())
+	mux.HandleFunc("/ping", Ping)
+	mux.HandleFunc("/-/healthy", Healthy)
+	mux.Handle("/-/ready", readyHandler(jenkinsCollectors))
+	mux.Handle(config.Global.MetricsPath, metricsHandler(jenkinsCollectors, registry))
+	mux.Handle("/probe", probeHandler(compileAnchored(config.Global.ProbeTargets)))
+
+	// Listen and serve, with the TLS and basic auth settings of the web configuration file.
+	// The file is read again on new connections, to reload the certificates
+	systemdSocket := false
+	flags := &web.FlagConfig{
+		WebListenAddresses: &[]string{config.Global.ExporterHostPort},
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      &config.Global.WebConfigFile,
+	}
+	// The scrapes outlive ctx to drain, they are cancelled when the grace period ends
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server := &http.Server{Handler: mux, BaseContext: func(net.Listener) context.Context { return requestsCtx }}
+	served := make(chan error, 1)
+	go func() {
+		served <- web.ListenAndServe(server, flags, webLogger{})
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	logrus.Info("Shutting down go-jenkins-exporter, waiting up to ", config.Global.ShutdownTimeout, " for the scrapes in progress")
+	cancel()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.Global.ShutdownTimeout)
+	defer cancelShutdown()
+	go func() {
+		<-shutdownCtx.Done()
+		cancelRequests()
+	}()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logrus.Warn("Grace period over, closing the remaining connections: ", err)
+		server.Close()
+	}
+	loops.Wait()
+	if err := <-served; err != http.ErrServerClosed {
+		return err
+	}
+	logrus.Info("Stopped go-jenkins-exporter")
+	return nil
+}
+
+// webLogger Send the logs of the exporter toolkit to logrus
+type webLogger struct{}
+
+func (webLogger) Log(keyvals ...interface{}) error {
+	fields := logrus.Fields{}
+	msg := ""
+	lvl := logrus.InfoLevel
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		switch key {
+		case "msg":
+			msg = fmt.Sprint(keyvals[i+1])
+		case "level":
+			if l, err := logrus.ParseLevel(fmt.Sprint(keyvals[i+1])); err == nil {
+				lvl = l
+			}
+		default:
+			fields[key] = keyvals[i+1]
+		}
+	}
+	logrus.WithFields(fields).Log(lvl, msg)
+	return nil
+}
+
+// metricsHandler Serve the registry, crawling the Jenkins instances beforehand in scrape mode
+func metricsHandler(jenkinsCollectors []*JenkinsCollector, registry *prometheus.Registry) http.Handler {
+	handler := newPromHandler(registry)
+	if config.Global.CollectionMode != ModeScrape {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Each instance is crawled concurrently within the scrape timeout, a slow one doesn't block the others
+		var wg sync.WaitGroup
+		for _, c := range jenkinsCollectors {
+			wg.Add(1)
+			go func(c *JenkinsCollector) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), scrapeTimeout(r, c.instance.Timeout))
+				defer cancel()
+				// On error, the last good snapshot is served along with jenkins_up 0
+				c.Refresh(ctx)
+			}(c)
+		}
+		wg.Wait()
+		handler.ServeHTTP(w, r)
+	})
+}
 
-	// Listen and serve
-	logrus.Info("Listening on " + config.Global.ExporterHostPort + " ...")
-	logrus.Fatal(http.ListenAndServe(config.Global.ExporterHostPort, nil))
+// scrapeTimeout Return the timeout given by Prometheus, or the default one
+func scrapeTimeout(r *http.Request, timeout time.Duration) time.Duration {
+	if header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); header != "" {
+		seconds, err := strconv.ParseFloat(header, 64)
+		if err != nil {
+			logrus.Warn("Invalid X-Prometheus-Scrape-Timeout-Seconds header: ", header)
+			return timeout
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+		if timeout > scrapeTimeoutOffset {
+			timeout -= scrapeTimeoutOffset
+		}
+	}
+	return timeout
 }
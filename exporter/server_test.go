@@ -0,0 +1,68 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+)
+
+func TestServeShutdown(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.ShutdownTimeout = 500 * time.Millisecond
+	})
+	crawling := make(chan struct{}, 1)
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case crawling <- struct{}{}:
+		default:
+		}
+		// Slow crawl, answering after the shutdown
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	config.Global.Instances = []config.Instance{*f.instance()}
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx)
+	}()
+	select {
+	case <-crawling:
+	case err := <-served:
+		t.Fatalf("Serve returned before the shutdown: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Jenkins not crawled")
+	}
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(config.Global.ShutdownTimeout + time.Second):
+		t.Fatal("Serve still running after the shutdown timeout")
+	}
+	if elapsed := time.Since(start); elapsed > config.Global.ShutdownTimeout {
+		t.Errorf("Serve stopped in %v, above the shutdown timeout of %v", elapsed, config.Global.ShutdownTimeout)
+	}
+
+	// The connections to Jenkins and the server goroutines take a moment to end
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		buf := make([]byte, 1<<20)
+		t.Errorf("%d goroutines left running, %d before Serve:\n%s", got-goroutines, goroutines, buf[:runtime.Stack(buf, true)])
+	}
+}
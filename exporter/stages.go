@@ -0,0 +1,145 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Jenkins pipeline stage struct, from the workflow API
+type jStage struct {
+	Name                string `json:"name"`
+	Status              string `json:"status"`
+	StartTimeMillis     int64  `json:"startTimeMillis"`
+	DurationMillis      int64  `json:"durationMillis"`
+	PauseDurationMillis int64  `json:"pauseDurationMillis"`
+}
+
+// Jenkins pipeline run struct, from the workflow API
+type jRun struct {
+	Status string   `json:"status"`
+	Stages []jStage `json:"stages"`
+}
+
+const pipelineJobClass = "org.jenkinsci.plugins.workflow.job.WorkflowJob"
+
+// Builds whose stages are fetched
+var stageStatuses = []string{
+	"lastBuild",
+	"lastCompletedBuild",
+}
+
+var stageMetrics map[string]*prometheus.Desc
+
+func initStageMetrics() {
+	stageMetrics = make(map[string]*prometheus.Desc)
+	for _, s := range stageStatuses {
+		// Status
+		stageMetrics[s+"Status"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_stage_status",
+			"Jenkins pipeline stage status for "+s,
+			jobLabels("stage"),
+			nil,
+		)
+		// Duration
+		stageMetrics[s+"Duration"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_stage_duration_seconds",
+			"Jenkins pipeline stage duration in seconds for "+s,
+			jobLabels("stage"),
+			nil,
+		)
+		// Pause duration
+		stageMetrics[s+"PauseDuration"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_stage_pause_duration_seconds",
+			"Jenkins pipeline stage pause duration in seconds for "+s,
+			jobLabels("stage"),
+			nil,
+		)
+		// Start time
+		stageMetrics[s+"StartTimestamp"] = prometheus.NewDesc(
+			"jenkins_job_"+toSnakeCase(s)+"_stage_start_timestamp_seconds",
+			"Jenkins pipeline stage start time in unixtime for "+s,
+			jobLabels("stage"),
+			nil,
+		)
+	}
+}
+
+// fetchStages Get the stages of the last builds of the pipeline jobs from the workflow API.
+// The stages are optional: a job whose stages can't be fetched is exported without them, only
+// the end of the crawl is an error
+func (api *jenkinsAPI) fetchStages(ctx context.Context, jobs []job) error {
+	for i := range jobs {
+		j := &jobs[i]
+		if j.Class != pipelineJobClass {
+			continue
+		}
+		j.Stages = make(map[string]*jRun, len(stageStatuses))
+		for _, s := range stageStatuses {
+			var run jRun
+			err := api.decodeJson(ctx, j.URL+s+"/wfapi/describe", &run)
+			if err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				// No such build, or the pipeline stage view plugin is missing
+				if errorKindOf(err) == ErrNotFound {
+					logrus.Debug("No stages for ", j.URL+s, ": ", err)
+					continue
+				}
+				logrus.Warn("Skipping the stages of ", j.URL, ": ", err)
+				j.Stages = nil
+				break
+			}
+			j.Stages[s] = &run
+		}
+	}
+	return nil
+}
+
+// collectStages Send the stage metrics of a job, up to the configured number of stages per build
+func collectStages(ch chan<- prometheus.Metric, j *job, labels []string) {
+	for s, run := range j.Stages {
+		seen := make(map[string]bool, len(run.Stages))
+		for _, stage := range run.Stages {
+			if len(seen) >= config.Global.MaxStages {
+				break
+			}
+			// Stage names are not unique, keep the first one
+			if seen[stage.Name] {
+				continue
+			}
+			seen[stage.Name] = true
+			gauge := func(key string, value float64) {
+				ch <- prometheus.MustNewConstMetric(stageMetrics[s+key], prometheus.GaugeValue, value, appendLabels(labels, stage.Name)...)
+			}
+			gauge("Status", whichStageStatus(stage.Status))
+			gauge("Duration", float64(stage.DurationMillis)/1000)
+			gauge("PauseDuration", float64(stage.PauseDurationMillis)/1000)
+			gauge("StartTimestamp", float64(stage.StartTimeMillis)/1000)
+		}
+	}
+}
+
+// Same encoding as whichResult
+func whichStageStatus(status string) float64 {
+	switch status {
+	case "FAILED":
+		return 0
+	case "UNSTABLE":
+		return 0.5
+	case "SUCCESS":
+		return 1
+	case "ABORTED":
+		return 2
+	case "NOT_EXECUTED":
+		return 3
+	case "IN_PROGRESS", "PAUSED_PENDING_INPUT":
+		return 4
+	default:
+		// Return for unknown values
+		return 100
+	}
+}
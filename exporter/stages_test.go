@@ -0,0 +1,161 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func readDescribe(t *testing.T) []byte {
+	describe, err := ioutil.ReadFile("testdata/wfapi-describe.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return describe
+}
+
+func TestFetchStages(t *testing.T) {
+	setTestConfig(t, nil)
+	describe := readDescribe(t)
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/job/pipe/lastBuild/wfapi/describe":
+			w.Write(describe)
+		case "/job/broken/lastBuild/wfapi/describe":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		default:
+			// No completed build
+			http.NotFound(w, r)
+		}
+	}))
+	api := newJenkinsAPI(f.instance())
+	jobs := []job{
+		{Class: pipelineJobClass, URL: f.URL + "/job/pipe/"},
+		{Class: "hudson.model.FreeStyleProject", URL: f.URL + "/job/free/"},
+	}
+	if err := api.fetchStages(context.Background(), jobs); err != nil {
+		t.Fatal(err)
+	}
+	run := jobs[0].Stages["lastBuild"]
+	if run == nil || len(run.Stages) != 5 || run.Stages[2].Name != "Test" || run.Stages[2].DurationMillis != 60000 {
+		t.Errorf("got lastBuild run %+v, want the 5 stages of the fixture", run)
+	}
+	if _, ok := jobs[0].Stages["lastCompletedBuild"]; ok {
+		t.Error("got stages for the lastCompletedBuild answering 404")
+	}
+	if jobs[1].Stages != nil {
+		t.Errorf("got stages %v for a freestyle job", jobs[1].Stages)
+	}
+	if got := f.count("/job/free/lastBuild/wfapi/describe"); got != 0 {
+		t.Errorf("freestyle job stages requested %d times", got)
+	}
+
+	// The stages of the job are skipped, not the crawl
+	broken := []job{{Class: pipelineJobClass, URL: f.URL + "/job/broken/"}, {Class: pipelineJobClass, URL: f.URL + "/job/pipe/"}}
+	if err := api.fetchStages(context.Background(), broken); err != nil {
+		t.Errorf("got error %v for a broken stage view", err)
+	}
+	if broken[0].Stages != nil || broken[1].Stages["lastBuild"] == nil {
+		t.Errorf("got stages %v and %v, want only the ones of the second job", broken[0].Stages, broken[1].Stages)
+	}
+}
+
+func TestStagesErrorKeepsCrawl(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.PipelineStages = true
+	})
+	describe := readDescribe(t)
+	tree := fakeTree{"/": {{Name: "pipe", Class: pipelineJobClass}, {Name: "broken", Class: pipelineJobClass}, {Name: "free"}}}
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/job/pipe/lastBuild/wfapi/describe":
+			w.Write(describe)
+		case strings.HasPrefix(r.URL.Path, "/job/broken/"):
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case strings.HasSuffix(r.URL.Path, "/wfapi/describe"):
+			http.NotFound(w, r)
+		default:
+			tree.ServeHTTP(w, r)
+		}
+	}))
+	c := NewJenkinsCollector(f.instance(), nil)
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatalf("got error %v for a broken stage view", err)
+	}
+	if got := testutil.ToFloat64(c.up); got != 1 {
+		t.Errorf("got jenkins_up %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(c, "jenkins_job_last_build_number"); got != 3 {
+		t.Errorf("got %d jenkins_job_last_build_number series, want the 3 jobs", got)
+	}
+	if got := testutil.CollectAndCount(c, "jenkins_job_last_build_stage_duration_seconds"); got != 4 {
+		t.Errorf("got %d stage durations, want the 4 stages of pipe", got)
+	}
+}
+
+// stagesCollector Collect the stage metrics of a job
+type stagesCollector struct {
+	j *job
+}
+
+func (c stagesCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range stageMetrics {
+		ch <- desc
+	}
+}
+
+func (c stagesCollector) Collect(ch chan<- prometheus.Metric) {
+	collectStages(ch, c.j, jobLabelValues(c.j))
+}
+
+func TestCollectStages(t *testing.T) {
+	initMetricsOnce.Do(initJobMetrics)
+	var run jRun
+	if err := json.Unmarshal(readDescribe(t), &run); err != nil {
+		t.Fatal(err)
+	}
+	j := &job{FullName: "pipe", Stages: map[string]*jRun{"lastBuild": &run}}
+	header := `
+		# HELP jenkins_job_last_build_stage_duration_seconds Jenkins pipeline stage duration in seconds for lastBuild
+		# TYPE jenkins_job_last_build_stage_duration_seconds gauge
+	`
+	tests := []struct {
+		name      string
+		maxStages int
+		want      string
+	}{
+		{"duplicate names", 20, `
+			jenkins_job_last_build_stage_duration_seconds{jobname="pipe",stage="Build"} 35
+			jenkins_job_last_build_stage_duration_seconds{jobname="pipe",stage="Checkout"} 1.2
+			jenkins_job_last_build_stage_duration_seconds{jobname="pipe",stage="Deploy"} 0
+			jenkins_job_last_build_stage_duration_seconds{jobname="pipe",stage="Test"} 60
+		`},
+		{"max stages", 3, `
+			jenkins_job_last_build_stage_duration_seconds{jobname="pipe",stage="Build"} 35
+			jenkins_job_last_build_stage_duration_seconds{jobname="pipe",stage="Checkout"} 1.2
+			jenkins_job_last_build_stage_duration_seconds{jobname="pipe",stage="Test"} 60
+		`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *config.Config) {
+				c.MaxStages = tt.maxStages
+			})
+			c := stagesCollector{j}
+			if err := testutil.CollectAndCompare(c, strings.NewReader(header+tt.want), "jenkins_job_last_build_stage_duration_seconds"); err != nil {
+				t.Error(err)
+			}
+			stages := strings.Count(tt.want, "stage=")
+			if got := testutil.CollectAndCount(c); got != 4*stages {
+				t.Errorf("got %d series, want %d for %d stages", got, 4*stages, stages)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package exporter
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+// Metric styles of the build result, color and cause
+const (
+	// MetricStyleLegacy One series per build, its value encoding the state, see the README
+	MetricStyleLegacy = "legacy"
+	// MetricStyleStateSet One 0/1 series per state, the state being a label
+	MetricStyleStateSet = "stateset"
+)
+
+// MetricStyles Supported metric styles
+var MetricStyles = []string{MetricStyleLegacy, MetricStyleStateSet}
+
+// States of the state sets, see whichResultState, whichColorState and whichCauseState
+var (
+	resultStates = []string{"SUCCESS", "FAILURE", "UNSTABLE", "ABORTED", "NOT_BUILT", "RUNNING"}
+	colorStates  = []string{"blue", "red", "yellow", "notbuilt", "disabled", "aborted", "grey"}
+	causeStates  = []string{"timer", "user", "upstream", "scm", "branch_indexing", "gitlab_webhook", "cli", "remote", "replay", "restart", "branch_event"}
+)
+
+// Return the state label of a state set, none in the legacy style
+func stateLabel(name string) []string {
+	if config.Global.MetricStyle != MetricStyleStateSet {
+		return nil
+	}
+	return []string{name}
+}
+
+// Check if the key of prepareMetrics is exported as a state set
+func isStateSetMetric(key string) bool {
+	return config.Global.MetricStyle == MetricStyleStateSet &&
+		(strings.HasSuffix(key, "Result") || strings.HasSuffix(key, "Color") || strings.HasSuffix(key, "Cause"))
+}
+
+// collectStateSets Send the result and cause of the builds, and the color of the last build, as state sets
+func collectStateSets(ch chan<- prometheus.Metric, j *job, labels []string) {
+	for _, s := range jobStatuses {
+		build := jobBuild(j, s)
+		collectStateSet(ch, prometheusMetrics[s+"Result"], labels, resultStates, whichResultState(build))
+		collectStateSet(ch, prometheusMetrics[s+"Cause"], labels, causeStates, whichCauseState(build))
+	}
+	collectStateSet(ch, prometheusMetrics["lastBuildColor"], labels, colorStates, whichColorState(j.ColorPtr))
+}
+
+// Send one series per state, 1 for the current one. Every series is 0 for an unknown or missing state
+func collectStateSet(ch chan<- prometheus.Metric, desc *prometheus.Desc, labels []string, states []string, current string) {
+	for _, state := range states {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, b2F64(state == current), appendLabels(labels, state)...)
+	}
+}
+
+func jobBuild(j *job, status string) jStatus {
+	switch status {
+	case "lastBuild":
+		return j.LastBuild
+	case "lastCompletedBuild":
+		return j.LastCompletedBuild
+	case "lastFailedBuild":
+		return j.LastFailedBuild
+	case "lastStableBuild":
+		return j.LastStableBuild
+	case "lastSuccessfulBuild":
+		return j.LastSuccessfulBuild
+	case "lastUnstableBuild":
+		return j.LastUnstableBuild
+	default:
+		return j.LastUnsuccessfulBuild
+	}
+}
+
+// Return the state label of the state set families by family name
+func stateSetFamilies() map[string]string {
+	families := make(map[string]string)
+	for _, s := range jobStatuses {
+		for _, state := range []string{"result", "color", "cause"} {
+			families["jenkins_job_"+toSnakeCase(s)+"_"+state] = state
+		}
+	}
+	return families
+}
+
+// newPromHandler Serve the gatherer, in the OpenMetrics format when negotiated. In the stateset style,
+// the state sets then have the stateset type, their state label being named after the metric as the
+// format requires. The client library has no stateset type, so these families are encoded here
+func newPromHandler(gatherer prometheus.Gatherer) http.Handler {
+	handler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	if config.Global.MetricStyle != MetricStyleStateSet {
+		return handler
+	}
+	families := stateSetFamilies()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		if format != expfmt.FmtOpenMetrics_0_0_1 && format != expfmt.FmtOpenMetrics_1_0_0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			logrus.Error("Error gathering metrics: ", err)
+			http.Error(w, "An error has occurred while gathering metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		for _, mf := range mfs {
+			name := mf.GetName()
+			state, isStateSet := families[name]
+			if isStateSet {
+				// The gathered families are built on each gathering, they can be changed
+				for _, m := range mf.Metric {
+					for _, l := range m.Label {
+						if l.GetName() == state {
+							l.Name = &name
+						}
+					}
+					labels := m.Label
+					sort.Slice(labels, func(i, k int) bool {
+						return labels[i].GetName() < labels[k].GetName()
+					})
+				}
+			}
+			start := buf.Len()
+			if _, err := expfmt.MetricFamilyToOpenMetrics(&buf, mf); err != nil {
+				logrus.Error("Error encoding metric family ", name, ": ", err)
+				http.Error(w, "An error has occurred while encoding metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if isStateSet {
+				family := bytes.Replace(buf.Bytes()[start:], []byte("# TYPE "+name+" gauge\n"), []byte("# TYPE "+name+" stateset\n"), 1)
+				buf.Truncate(start)
+				buf.Write(family)
+			}
+		}
+		expfmt.FinalizeOpenMetrics(&buf)
+		w.Header().Set("Content-Type", string(format))
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,178 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// setMetricStyle Create the job series descriptions of the style, the legacy ones are created again at the end of the test
+func setMetricStyle(t *testing.T, style string) {
+	initMetricsOnce.Do(initJobMetrics)
+	setTestConfig(t, func(c *config.Config) {
+		c.MetricStyle = style
+	})
+	initPrometheusMetrics()
+	t.Cleanup(func() {
+		config.Global.MetricStyle = MetricStyleLegacy
+		initPrometheusMetrics()
+	})
+}
+
+// Job whose last build failed, started by a timer, while a new build is running
+func failedJob() *jobsSource {
+	color := "red_anime"
+	return &jobsSource{
+		filter: newJobFilter(),
+		cache:  newFolderCache(),
+		jobs: &[]job{{
+			FullName: "app",
+			ColorPtr: &color,
+			LastBuild: jStatus{
+				Result:    "FAILURE",
+				Timestamp: 1700000000000,
+				Duration:  60000,
+				Actions:   []jActions{{Class: "hudson.model.CauseAction", Causes: []jCauses{{Class: "hudson.triggers.TimerTrigger$TimerTriggerCause"}}}},
+			},
+		}},
+	}
+}
+
+// stateSet Return the series of the state set of the last build, 1 for the current state
+func stateSet(name, label string, states []string, current string) string {
+	var lines []string
+	for _, state := range states {
+		value := 0
+		if state == current {
+			value = 1
+		}
+		// Labels are sorted by name
+		labels := fmt.Sprintf("jobname=\"app\",%s=%q", label, state)
+		if label < "jobname" {
+			labels = fmt.Sprintf("%s=%q,jobname=\"app\"", label, state)
+		}
+		lines = append(lines, fmt.Sprintf("jenkins_job_last_build_%s{%s} %d", name, labels, value))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+const stateSetHeader = `
+# HELP jenkins_job_last_build_cause Jenkins build cause for lastBuild
+# TYPE jenkins_job_last_build_cause gauge
+# HELP jenkins_job_last_build_color Jenkins build color for lastBuild
+# TYPE jenkins_job_last_build_color gauge
+# HELP jenkins_job_last_build_result Jenkins build result for lastBuild
+# TYPE jenkins_job_last_build_result gauge
+`
+
+var stateSetNames = []string{"jenkins_job_last_build_result", "jenkins_job_last_build_color", "jenkins_job_last_build_cause"}
+
+func TestLegacyStyle(t *testing.T) {
+	setMetricStyle(t, MetricStyleLegacy)
+	want := stateSetHeader + `
+		jenkins_job_last_build_cause{jobname="app"} 0
+		jenkins_job_last_build_color{jobname="app"} 1
+		jenkins_job_last_build_result{jobname="app"} 0
+	`
+	if err := testutil.CollectAndCompare(failedJob(), strings.NewReader(want), stateSetNames...); err != nil {
+		t.Error(err)
+	}
+	// No build
+	want = `
+		# HELP jenkins_job_last_completed_build_cause Jenkins build cause for lastCompletedBuild
+		# TYPE jenkins_job_last_completed_build_cause gauge
+		jenkins_job_last_completed_build_cause{jobname="app"} -1
+		# HELP jenkins_job_last_completed_build_result Jenkins build result for lastCompletedBuild
+		# TYPE jenkins_job_last_completed_build_result gauge
+		jenkins_job_last_completed_build_result{jobname="app"} 3
+	`
+	if err := testutil.CollectAndCompare(failedJob(), strings.NewReader(want), "jenkins_job_last_completed_build_cause", "jenkins_job_last_completed_build_result"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStateSetStyle(t *testing.T) {
+	setMetricStyle(t, MetricStyleStateSet)
+	want := stateSetHeader +
+		stateSet("cause", "cause", causeStates, "timer") +
+		stateSet("color", "color", colorStates, "red") +
+		stateSet("result", "result", resultStates, "FAILURE")
+	if err := testutil.CollectAndCompare(failedJob(), strings.NewReader(want), stateSetNames...); err != nil {
+		t.Error(err)
+	}
+	// No build, no cause
+	if got := testutil.CollectAndCount(failedJob(), "jenkins_job_last_completed_build_cause"); got != len(causeStates) {
+		t.Errorf("got %d series, want one per cause", got)
+	}
+	// The other series keep their value
+	if got := testutil.CollectAndCount(failedJob(), "jenkins_job_last_build_number"); got != 1 {
+		t.Errorf("got %d build number series, want 1", got)
+	}
+}
+
+// scrape Return the content type and the metrics served by newPromHandler in the accepted format
+func scrape(t *testing.T, accept string) (string, string) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(failedJob())
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", accept)
+	rec := httptest.NewRecorder()
+	newPromHandler(registry).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	return rec.Header().Get("Content-Type"), rec.Body.String()
+}
+
+const openMetricsAccept = "application/openmetrics-text; version=1.0.0"
+
+func TestStateSetOpenMetrics(t *testing.T) {
+	setMetricStyle(t, MetricStyleStateSet)
+	contentType, body := scrape(t, openMetricsAccept)
+	if !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("got content type %s, want OpenMetrics", contentType)
+	}
+	for _, want := range []string{
+		"# TYPE jenkins_job_last_build_result stateset\n",
+		`jenkins_job_last_build_result{jenkins_job_last_build_result="FAILURE",jobname="app"} 1.0` + "\n",
+		`jenkins_job_last_build_result{jenkins_job_last_build_result="SUCCESS",jobname="app"} 0.0` + "\n",
+		"# TYPE jenkins_job_last_build_color stateset\n",
+		`jenkins_job_last_build_color{jenkins_job_last_build_color="red",jobname="app"} 1.0` + "\n",
+		"# TYPE jenkins_job_last_build_cause stateset\n",
+		`jenkins_job_last_build_cause{jenkins_job_last_build_cause="timer",jobname="app"} 1.0` + "\n",
+		"# TYPE jenkins_job_last_build_number gauge\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q missing from the OpenMetrics output", want)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Error("OpenMetrics output not terminated by # EOF")
+	}
+
+	// The text format keeps the gauges and the state labels
+	contentType, body = scrape(t, "text/plain")
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("got content type %s, want text", contentType)
+	}
+	if !strings.Contains(body, "# TYPE jenkins_job_last_build_result gauge\n") || !strings.Contains(body, `jenkins_job_last_build_result{jobname="app",result="FAILURE"} 1`+"\n") {
+		t.Errorf("state set gauge missing from the text output:\n%s", body)
+	}
+}
+
+func TestLegacyOpenMetrics(t *testing.T) {
+	setMetricStyle(t, MetricStyleLegacy)
+	contentType, body := scrape(t, openMetricsAccept)
+	if !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("got content type %s, want OpenMetrics", contentType)
+	}
+	if strings.Contains(body, "stateset") || !strings.Contains(body, `jenkins_job_last_build_result{jobname="app"} 0.0`+"\n") {
+		t.Errorf("got legacy result series not as a gauge:\n%s", body)
+	}
+}
@@ -0,0 +1,182 @@
+package exporter
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Jenkins test case struct
+type jTestCase struct {
+	ClassName string  `json:"className"`
+	Name      string  `json:"name"`
+	Duration  float64 `json:"duration"`
+	Status    string  `json:"status"`
+}
+
+// Jenkins test suite struct
+type jTestSuite struct {
+	Name     string      `json:"name"`
+	Duration float64     `json:"duration"`
+	Cases    []jTestCase `json:"cases"`
+}
+
+// Jenkins test report struct, aggregated reports hold their suites in child reports
+type jTestReport struct {
+	Suites       []jTestSuite `json:"suites"`
+	ChildReports []struct {
+		Result struct {
+			Suites []jTestSuite `json:"suites"`
+		} `json:"result"`
+	} `json:"childReports"`
+}
+
+const testReportQuery = "?tree=suites[name,duration,cases[className,name,duration,status]]," +
+	"childReports[result[suites[name,duration,cases[className,name,duration,status]]]]"
+
+// Summary of a test report, the cases are dropped once the slowest and failing ones are known
+type testReportSummary struct {
+	Suites  []testSuiteSummary
+	Slowest []testCaseSummary
+	Failing []testCaseSummary
+}
+
+type testSuiteSummary struct {
+	Name     string
+	Duration float64
+	Passed   int
+	Failed   int
+	Skipped  int
+}
+
+type testCaseSummary struct {
+	Suite    string
+	Name     string
+	Duration float64
+}
+
+func newTestReportDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		"jenkins_job_last_completed_build_"+name,
+		help,
+		jobLabels(labels...),
+		nil,
+	)
+}
+
+var testSuiteCountDesc, testSuiteDurationDesc, testCaseDurationDesc, testCaseFailedDesc *prometheus.Desc
+
+func initTestReportMetrics() {
+	testSuiteCountDesc = newTestReportDesc("test_suite_count", "Jenkins test count by status of the suite for lastCompletedBuild", "suite", "status")
+	testSuiteDurationDesc = newTestReportDesc("test_suite_duration_seconds", "Jenkins test suite duration in seconds for lastCompletedBuild", "suite")
+	testCaseDurationDesc = newTestReportDesc("test_case_duration_seconds", "Jenkins slowest test cases duration in seconds for lastCompletedBuild", "suite", "case")
+	testCaseFailedDesc = newTestReportDesc("test_case_failed", "Jenkins failing test cases for lastCompletedBuild", "suite", "case")
+}
+
+// fetchTestReports Get the test report of the last completed build of the jobs having test results.
+// A report that can't be read, too large or broken, is skipped: only the end of the crawl is an error
+func (api *jenkinsAPI) fetchTestReports(ctx context.Context, jobs []job) error {
+	for i := range jobs {
+		j := &jobs[i]
+		counts := make(map[string]float64)
+		setTestCounts(counts, "lastCompletedBuild", j.LastCompletedBuild.Actions)
+		if len(counts) == 0 {
+			continue
+		}
+		var report jTestReport
+		err := api.decodeJson(ctx, j.URL+"lastCompletedBuild/testReport/api/json"+testReportQuery, &report)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			// The report may have been deleted with the build since the crawl of the folder
+			if errorKindOf(err) == ErrNotFound {
+				logrus.Debug("No test report for ", j.URL, ": ", err)
+				continue
+			}
+			logrus.Warn("Skipping the test report of ", j.URL, ": ", err)
+			continue
+		}
+		j.TestReport = summarizeTestReport(&report, config.Global.TestReportTop)
+	}
+	return nil
+}
+
+func summarizeTestReport(report *jTestReport, top int) *testReportSummary {
+	suites := report.Suites
+	for _, child := range report.ChildReports {
+		suites = append(suites, child.Result.Suites...)
+	}
+	summary := &testReportSummary{}
+	var cases, failing []testCaseSummary
+	for _, suite := range suites {
+		s := testSuiteSummary{Name: suite.Name, Duration: suite.Duration}
+		for _, c := range suite.Cases {
+			tc := testCaseSummary{Suite: suite.Name, Name: c.ClassName + "." + c.Name, Duration: c.Duration}
+			switch c.Status {
+			case "FAILED", "REGRESSION":
+				s.Failed++
+				failing = append(failing, tc)
+			case "SKIPPED":
+				s.Skipped++
+			default:
+				// PASSED and FIXED
+				s.Passed++
+			}
+			cases = append(cases, tc)
+		}
+		summary.Suites = append(summary.Suites, s)
+	}
+	summary.Slowest = topTestCases(cases, top)
+	summary.Failing = topTestCases(failing, top)
+	return summary
+}
+
+// Return the n slowest cases
+func topTestCases(cases []testCaseSummary, n int) []testCaseSummary {
+	if n <= 0 {
+		return nil
+	}
+	sort.SliceStable(cases, func(i, k int) bool {
+		return cases[i].Duration > cases[k].Duration
+	})
+	if len(cases) > n {
+		cases = cases[:n]
+	}
+	return cases
+}
+
+func collectTestReport(ch chan<- prometheus.Metric, j *job, labels []string) {
+	if j.TestReport == nil {
+		return
+	}
+	seen := make(map[string]bool, len(j.TestReport.Suites))
+	for _, s := range j.TestReport.Suites {
+		// Suite names are not unique across child reports, keep the first one
+		if seen[s.Name] {
+			continue
+		}
+		seen[s.Name] = true
+		ch <- prometheus.MustNewConstMetric(testSuiteDurationDesc, prometheus.GaugeValue, s.Duration, appendLabels(labels, s.Name)...)
+		ch <- prometheus.MustNewConstMetric(testSuiteCountDesc, prometheus.GaugeValue, i2F64(s.Passed), appendLabels(labels, s.Name, "passed")...)
+		ch <- prometheus.MustNewConstMetric(testSuiteCountDesc, prometheus.GaugeValue, i2F64(s.Failed), appendLabels(labels, s.Name, "failed")...)
+		ch <- prometheus.MustNewConstMetric(testSuiteCountDesc, prometheus.GaugeValue, i2F64(s.Skipped), appendLabels(labels, s.Name, "skipped")...)
+	}
+	collectTestCases(ch, testCaseDurationDesc, j.TestReport.Slowest, labels, func(c testCaseSummary) float64 { return c.Duration })
+	collectTestCases(ch, testCaseFailedDesc, j.TestReport.Failing, labels, func(c testCaseSummary) float64 { return 1 })
+}
+
+func collectTestCases(ch chan<- prometheus.Metric, desc *prometheus.Desc, cases []testCaseSummary, labels []string, value func(testCaseSummary) float64) {
+	seen := make(map[testCaseSummary]bool, len(cases))
+	for _, c := range cases {
+		key := testCaseSummary{Suite: c.Suite, Name: c.Name}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value(c), appendLabels(labels, c.Suite, c.Name)...)
+	}
+}
@@ -0,0 +1,167 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testReportReply = `{
+	"suites": [
+		{"name": "unit", "duration": 3.5, "cases": [
+			{"className": "app.Parser", "name": "testEmpty", "duration": 0.5, "status": "PASSED"},
+			{"className": "app.Parser", "name": "testNested", "duration": 2, "status": "REGRESSION"},
+			{"className": "app.Parser", "name": "testLarge", "duration": 1, "status": "SKIPPED"}
+		]}
+	],
+	"childReports": [
+		{"result": {"suites": [
+			{"name": "integration", "duration": 30, "cases": [
+				{"className": "app.Server", "name": "testStart", "duration": 20, "status": "FIXED"},
+				{"className": "app.Server", "name": "testStop", "duration": 10, "status": "FAILED"}
+			]}
+		]}}
+	]
+}`
+
+func TestSummarizeTestReport(t *testing.T) {
+	var report jTestReport
+	if err := json.Unmarshal([]byte(testReportReply), &report); err != nil {
+		t.Fatal(err)
+	}
+	suites := []testSuiteSummary{
+		{Name: "unit", Duration: 3.5, Passed: 1, Failed: 1, Skipped: 1},
+		{Name: "integration", Duration: 30, Passed: 1, Failed: 1},
+	}
+	tests := []struct {
+		top     int
+		slowest []testCaseSummary
+		failing []testCaseSummary
+	}{
+		{0, nil, nil},
+		{1, []testCaseSummary{
+			{Suite: "integration", Name: "app.Server.testStart", Duration: 20},
+		}, []testCaseSummary{
+			{Suite: "integration", Name: "app.Server.testStop", Duration: 10},
+		}},
+		{10, []testCaseSummary{
+			{Suite: "integration", Name: "app.Server.testStart", Duration: 20},
+			{Suite: "integration", Name: "app.Server.testStop", Duration: 10},
+			{Suite: "unit", Name: "app.Parser.testNested", Duration: 2},
+			{Suite: "unit", Name: "app.Parser.testLarge", Duration: 1},
+			{Suite: "unit", Name: "app.Parser.testEmpty", Duration: 0.5},
+		}, []testCaseSummary{
+			{Suite: "integration", Name: "app.Server.testStop", Duration: 10},
+			{Suite: "unit", Name: "app.Parser.testNested", Duration: 2},
+		}},
+	}
+	for _, tt := range tests {
+		summary := summarizeTestReport(&report, tt.top)
+		if !reflect.DeepEqual(summary.Suites, suites) {
+			t.Errorf("top %d: got suites %+v, want %+v", tt.top, summary.Suites, suites)
+		}
+		if !reflect.DeepEqual(summary.Slowest, tt.slowest) {
+			t.Errorf("top %d: got slowest %+v, want %+v", tt.top, summary.Slowest, tt.slowest)
+		}
+		if !reflect.DeepEqual(summary.Failing, tt.failing) {
+			t.Errorf("top %d: got failing %+v, want %+v", tt.top, summary.Failing, tt.failing)
+		}
+	}
+	// Rejected by the flags checks, but a negative top must not panic in a crawl
+	if summary := summarizeTestReport(&report, -1); summary.Slowest != nil || summary.Failing != nil {
+		t.Errorf("got %+v with a negative top, want no cases", summary)
+	}
+}
+
+func TestFetchTestReports(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.TestReportTop = 1
+	})
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/job/app/lastCompletedBuild/testReport/api/json":
+			w.Write([]byte(testReportReply))
+		default:
+			// Report deleted with the build
+			http.NotFound(w, r)
+		}
+	}))
+	withTests := jStatus{Actions: []jActions{{Class: "hudson.tasks.junit.TestResultAction", TotalCount: 5, FailCount: 2}}}
+	jobs := []job{
+		{URL: f.URL + "/job/app/", LastCompletedBuild: withTests},
+		{URL: f.URL + "/job/deleted/", LastCompletedBuild: withTests},
+		{URL: f.URL + "/job/untested/"},
+	}
+	if err := newJenkinsAPI(f.instance()).fetchTestReports(context.Background(), jobs); err != nil {
+		t.Fatal(err)
+	}
+	if r := jobs[0].TestReport; r == nil || len(r.Suites) != 2 || len(r.Slowest) != 1 || len(r.Failing) != 1 {
+		t.Errorf("got report %+v, want the 2 suites and a single case of each top", r)
+	}
+	if jobs[1].TestReport != nil || jobs[2].TestReport != nil {
+		t.Error("got a report for a job without one")
+	}
+	if got := f.count("/job/untested/lastCompletedBuild/testReport/api/json"); got != 0 {
+		t.Errorf("report of a job without test results requested %d times", got)
+	}
+}
+
+func TestTestReportErrorKeepsCrawl(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.TestReport = true
+		c.MaxReplyBytes = 4096
+	})
+	tree := fakeTree{"/": {{Name: "app"}, {Name: "huge"}, {Name: "broken"}}}
+	f := newFakeJenkins(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/json":
+			// The last completed builds have test results
+			var reply struct {
+				Jobs []map[string]interface{} `json:"jobs"`
+			}
+			rec := httptest.NewRecorder()
+			tree.ServeHTTP(rec, r)
+			if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+				t.Error(err)
+			}
+			for _, j := range reply.Jobs {
+				j["lastCompletedBuild"] = map[string]interface{}{
+					"number":  1,
+					"actions": []map[string]interface{}{{"_class": "hudson.tasks.junit.TestResultAction", "totalCount": 5, "failCount": 2}},
+				}
+			}
+			json.NewEncoder(w).Encode(reply)
+		case "/job/app/lastCompletedBuild/testReport/api/json":
+			w.Write([]byte(testReportReply))
+		case "/job/huge/lastCompletedBuild/testReport/api/json":
+			w.Write([]byte(`{"suites":[{"name":"` + strings.Repeat("x", 8192) + `"}]}`))
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	c := NewJenkinsCollector(f.instance(), nil)
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatalf("got error %v for broken test reports", err)
+	}
+	if got := testutil.ToFloat64(c.up); got != 1 {
+		t.Errorf("got jenkins_up %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(c, "jenkins_job_last_completed_build_fail_count"); got != 3 {
+		t.Errorf("got %d jenkins_job_last_completed_build_fail_count series, want the 3 jobs", got)
+	}
+	if got := testutil.CollectAndCount(c, "jenkins_job_last_completed_build_test_suite_duration_seconds"); got != 2 {
+		t.Errorf("got %d suite durations, want the 2 suites of app", got)
+	}
+	for _, name := range []string{"huge", "broken"} {
+		if got := f.count("/job/" + name + "/lastCompletedBuild/testReport/api/json"); got != 1 {
+			t.Errorf("report of %s requested %d times, want once", name, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+	"github.com/sirupsen/logrus"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSVersions Supported minimum TLS versions
+var TLSVersions = []string{"1.0", "1.1", "1.2", "1.3"}
+
+// transportKey Settings a transport is built from
+type transportKey struct {
+	tls                config.InstanceTLS
+	minVersion         string
+	insecureSkipVerify bool
+}
+
+// Transports by settings, the clients of the instances and the probes with the same TLS settings share one
+var transports = struct {
+	sync.Mutex
+	byKey map[transportKey]http.RoundTripper
+}{byKey: make(map[transportKey]http.RoundTripper)}
+
+// SetupTransport Check the TLS settings of the instances and of the probe modules, so that they fail at startup
+func SetupTransport() error {
+	if _, ok := tlsVersions[config.Global.TLSMinVersion]; !ok {
+		return fmt.Errorf("invalid minimum TLS version %q", config.Global.TLSMinVersion)
+	}
+	if config.Global.TLSInsecureSkipVerify {
+		logrus.Warn("The certificates of Jenkins are not verified")
+	}
+	for i := range config.Global.Instances {
+		instance := &config.Global.Instances[i]
+		if _, err := transportFor(instance); err != nil {
+			return fmt.Errorf("instance %s: %w", instance.Name, err)
+		}
+	}
+	for name, module := range config.Global.ProbeModules {
+		if _, err := transportFor(&config.Instance{TLS: module.TLS}); err != nil {
+			return fmt.Errorf("probe module %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// transportFor Return the transport of the TLS settings of the instance
+func transportFor(instance *config.Instance) (http.RoundTripper, error) {
+	key := transportKey{
+		tls:                instance.TLS,
+		minVersion:         config.Global.TLSMinVersion,
+		insecureSkipVerify: config.Global.TLSInsecureSkipVerify,
+	}
+	transports.Lock()
+	defer transports.Unlock()
+	if t, ok := transports.byKey[key]; ok {
+		return t, nil
+	}
+	tlsConfig, err := newTLSConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	transports.byKey[key] = t
+	return t, nil
+}
+
+func newTLSConfig(key transportKey) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[key.minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid minimum TLS version %q", key.minVersion)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		ServerName:         key.tls.ServerName,
+		InsecureSkipVerify: key.insecureSkipVerify,
+	}
+	if key.tls.CAFile != "" {
+		pem, err := ioutil.ReadFile(key.tls.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the CA file: %w", err)
+		}
+		// The system roots are kept, the CA file adds the internal ones
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in the CA file %s", key.tls.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if key.tls.CertFile != "" || key.tls.KeyFile != "" {
+		if key.tls.CertFile == "" || key.tls.KeyFile == "" {
+			return nil, fmt.Errorf("the client certificate and key files must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(key.tls.CertFile, key.tls.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// failingTransport Transport of an instance whose TLS settings can't be loaded, every request fails
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
@@ -0,0 +1,170 @@
+package exporter
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodbins/go-jenkins-exporter/config"
+)
+
+// writePEM Write the PEM block to a file of the test directory and return its path
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCert Create a self signed client certificate, return its files and the pool trusting it
+func newClientCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "exporter"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return writePEM(t, "client.crt", "CERTIFICATE", der), writePEM(t, "client.key", "EC PRIVATE KEY", keyDER), pool
+}
+
+// newTLSJenkins Start a https server with the test certificate, valid for example.com and 127.0.0.1
+func newTLSJenkins(t *testing.T, tlsConfig *tls.Config) (*httptest.Server, string) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jobs":[]}`))
+	}))
+	srv.TLS = tlsConfig
+	// The refused handshakes are expected
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, writePEM(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+}
+
+// getTLS Request the server with the TLS settings of the instance
+func getTLS(srv *httptest.Server, files config.InstanceTLS) error {
+	instance := &config.Instance{
+		Name:     "tls",
+		SSLOn:    true,
+		HostPort: strings.TrimPrefix(srv.URL, "https://"),
+		Timeout:  5 * time.Second,
+		TLS:      files,
+	}
+	client := NewClient(instance)
+	resp, err := client.Do(context.Background(), http.MethodGet, client.BaseURL()+"api/json", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestTLSCustomCA(t *testing.T) {
+	setTestConfig(t, nil)
+	srv, ca := newTLSJenkins(t, nil)
+	if err := getTLS(srv, config.InstanceTLS{}); err == nil {
+		t.Error("certificate signed by an unknown CA accepted")
+	}
+	if err := getTLS(srv, config.InstanceTLS{CAFile: ca}); err != nil {
+		t.Errorf("certificate of the CA file refused: %v", err)
+	}
+}
+
+func TestTLSServerNamePerInstance(t *testing.T) {
+	setTestConfig(t, nil)
+	srv, ca := newTLSJenkins(t, nil)
+	if err := getTLS(srv, config.InstanceTLS{CAFile: ca, ServerName: "example.com"}); err != nil {
+		t.Errorf("certificate of example.com refused: %v", err)
+	}
+	// Same server, another instance expecting another name
+	if err := getTLS(srv, config.InstanceTLS{CAFile: ca, ServerName: "jenkins.internal"}); err == nil {
+		t.Error("certificate of example.com accepted for jenkins.internal")
+	}
+}
+
+func TestTLSClientCert(t *testing.T) {
+	setTestConfig(t, nil)
+	certFile, keyFile, pool := newClientCert(t)
+	srv, ca := newTLSJenkins(t, &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool})
+	if err := getTLS(srv, config.InstanceTLS{CAFile: ca}); err == nil {
+		t.Error("request without client certificate accepted")
+	}
+	if err := getTLS(srv, config.InstanceTLS{CAFile: ca, CertFile: certFile, KeyFile: keyFile}); err != nil {
+		t.Errorf("request with the client certificate refused: %v", err)
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	for _, tt := range []struct {
+		minVersion string
+		ok         bool
+	}{
+		{"1.2", true},
+		{"1.3", false},
+	} {
+		t.Run(tt.minVersion, func(t *testing.T) {
+			setTestConfig(t, func(c *config.Config) {
+				c.TLSMinVersion = tt.minVersion
+			})
+			srv, ca := newTLSJenkins(t, &tls.Config{MaxVersion: tls.VersionTLS12})
+			if err := getTLS(srv, config.InstanceTLS{CAFile: ca}); (err == nil) != tt.ok {
+				t.Errorf("got error %v against a TLS 1.2 server, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTLSInsecureSkipVerify(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.TLSInsecureSkipVerify = true
+	})
+	srv, _ := newTLSJenkins(t, nil)
+	if err := getTLS(srv, config.InstanceTLS{ServerName: "jenkins.internal"}); err != nil {
+		t.Errorf("certificate refused without verification: %v", err)
+	}
+}
+
+func TestTLSInvalidFiles(t *testing.T) {
+	setTestConfig(t, func(c *config.Config) {
+		c.Instances = []config.Instance{{Name: "broken", TLS: config.InstanceTLS{CAFile: filepath.Join(t.TempDir(), "missing.pem")}}}
+	})
+	if err := SetupTransport(); err == nil || !strings.Contains(err.Error(), "instance broken") {
+		t.Errorf("got error %v, want the missing CA file of instance broken", err)
+	}
+}